@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+)
+
+// These errors are returned by ClientConn.CanJoin.
+var (
+	ErrInexistentServer = errors.New("inexistent server")
+	ErrMissingPerm      = errors.New("missing permission")
+	ErrGroupDenied      = errors.New("group not allowed")
+	ErrServerFull       = errors.New("server is full")
+)
+
+// CanJoin checks whether the ClientConn is allowed to join
+// the specified server. It returns nil if it is and an error
+// wrapping one of the Err* variables of this package otherwise.
+// Server groups are not resolved.
+func (cc *ClientConn) CanJoin(server string) error {
+	srv, ok := Conf().Servers[server]
+	if !ok {
+		return ErrInexistentServer
+	}
+
+	if srv.Perm != "" && !cc.HasPerms(srv.Perm) {
+		return fmt.Errorf("%w %s", ErrMissingPerm, srv.Perm)
+	}
+
+	if len(srv.AllowGroups) > 0 || len(srv.DenyGroups) > 0 {
+		grp := UserGroup(cc.Name())
+
+		if len(srv.AllowGroups) > 0 && !containsString(srv.AllowGroups, grp) {
+			return fmt.Errorf("%w: %s", ErrGroupDenied, grp)
+		}
+
+		if containsString(srv.DenyGroups, grp) {
+			return fmt.Errorf("%w: %s", ErrGroupDenied, grp)
+		}
+	}
+
+	if srv.MaxPlayers > 0 && cc.ServerName() != server && ServerPlayers(server) >= srv.MaxPlayers {
+		return ErrServerFull
+	}
+
+	return nil
+}
+
+// ServerPlayers returns the number of players
+// that are connected to the specified server.
+func ServerPlayers(server string) int {
+	var n int
+	for cc := range Clts() {
+		if cc.ServerName() == server {
+			n++
+		}
+	}
+
+	return n
+}
+
+// serverFull reports whether the player cap of a server is reached.
+func serverFull(conf Config, server string) bool {
+	max := conf.Servers[server].MaxPlayers
+	return max > 0 && ServerPlayers(server) >= max
+}
+
+func containsString(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+
+	return false
+}
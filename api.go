@@ -0,0 +1,386 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type apiPlayer struct {
+	Name    string `json:"name"`
+	Addr    string `json:"address"`
+	Server  string `json:"server"`
+	Version string `json:"version"`
+	Proto   string `json:"proto_version"`
+}
+
+type apiServer struct {
+	Name      string     `json:"name"`
+	Addr      string     `json:"address"`
+	MediaPool string     `json:"media_pool"`
+	Fallbacks []string   `json:"fallbacks"`
+	Dynamic   bool       `json:"dynamic"`
+	Health    *apiHealth `json:"health,omitempty"`
+}
+
+type apiHealth struct {
+	Up        bool      `json:"up"`
+	LatencyMs float64   `json:"latency_ms"`
+	LastCheck time.Time `json:"last_check"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type apiBan struct {
+	Addr     string    `json:"address"`
+	Name     string    `json:"name"`
+	Reason   string    `json:"reason"`
+	Issuer   string    `json:"issuer"`
+	Created  time.Time `json:"created"`
+	Expiry   time.Time `json:"expiry"`
+	Duration string    `json:"duration,omitempty"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func serveAPI() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/players", apiAuth(apiPlayers))
+	mux.HandleFunc("/api/players/", apiAuth(apiPlayerAction))
+	mux.HandleFunc("/api/servers", apiAuth(apiServers))
+	mux.HandleFunc("/api/servers/", apiAuth(apiServerAction))
+	mux.HandleFunc("/api/bans", apiAuth(apiBans))
+	mux.HandleFunc("/api/bans/", apiAuth(apiUnban))
+	mux.HandleFunc("/api/config/reload", apiAuth(apiReload))
+
+	ln, err := net.Listen("tcp", Conf().API.Addr)
+	if err != nil {
+		return err
+	}
+
+	log.Println("listen api", ln.Addr())
+
+	srv := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		ErrorLog:     log.New(logWriter, "[api] ", log.LstdFlags|log.Lmsgprefix),
+	}
+
+	go func() {
+		<-consoleCh
+		srv.Close()
+	}()
+
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+func apiLog(r *http.Request, v ...interface{}) {
+	prefix := fmt.Sprintf("[api %s] ", r.RemoteAddr)
+	l := log.New(logWriter, prefix, log.LstdFlags|log.Lmsgprefix)
+	l.Println(v...)
+}
+
+// apiAuth wraps a handler and rejects requests
+// that don't carry one of the configured bearer tokens.
+func apiAuth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		for _, t := range Conf().API.Tokens {
+			if t != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+				apiLog(r, "->", r.Method, r.URL.Path)
+				h(w, r)
+				return
+			}
+		}
+
+		apiLog(r, "<-", "deny", r.Method, r.URL.Path)
+		apiWriteError(w, http.StatusUnauthorized, "invalid token")
+	}
+}
+
+func apiWrite(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiWriteError(w http.ResponseWriter, status int, msg string) {
+	apiWrite(w, status, apiError{Error: msg})
+}
+
+func apiReadJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		apiWriteError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+
+	return true
+}
+
+func apiMethodNotAllowed(w http.ResponseWriter) {
+	apiWriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// GET /api/players
+func apiPlayers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apiMethodNotAllowed(w)
+		return
+	}
+
+	players := make([]apiPlayer, 0)
+	for cc := range Clts() {
+		if cc.Name() == "" {
+			continue
+		}
+
+		players = append(players, apiPlayer{
+			Name:    cc.Name(),
+			Addr:    cc.RemoteAddr().String(),
+			Server:  cc.ServerName(),
+			Version: cc.versionStr,
+			Proto:   fmt.Sprintf("%d.%d.%d", cc.major, cc.minor, cc.patch),
+		})
+	}
+
+	apiWrite(w, http.StatusOK, players)
+}
+
+// POST /api/players/{name}/hop {"server": "..."}
+// POST /api/players/{name}/kick {"reason": "..."}
+func apiPlayerAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiMethodNotAllowed(w)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/players/"), "/")
+	if len(parts) != 2 {
+		apiWriteError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	cc := Find(parts[0])
+	if cc == nil {
+		apiWriteError(w, http.StatusNotFound, "player not connected")
+		return
+	}
+
+	switch parts[1] {
+	case "hop":
+		var req struct {
+			Server string `json:"server"`
+		}
+		if !apiReadJSON(w, r, &req) {
+			return
+		}
+
+		if err := cc.Hop(req.Server); err != nil {
+			apiWriteError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+	case "kick":
+		var req struct {
+			Reason string `json:"reason"`
+		}
+		if !apiReadJSON(w, r, &req) {
+			return
+		}
+
+		if req.Reason == "" {
+			req.Reason = "Kicked by proxy."
+		}
+
+		cc.Kick(req.Reason)
+	default:
+		apiWriteError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /api/servers
+// POST /api/servers {"name": "...", "address": "...", ...}
+func apiServers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		servers := make([]apiServer, 0)
+		conf := Conf()
+		for _, name := range conf.ServerNames() {
+			srv := conf.Servers[name]
+			s := apiServer{
+				Name:      name,
+				Addr:      srv.Addr,
+				MediaPool: srv.MediaPool,
+				Fallbacks: srv.Fallbacks,
+				Dynamic:   srv.dynamic,
+			}
+
+			if h, ok := ServerHealthInfo(name); ok && Conf().HealthCheck.Enable {
+				s.Health = &apiHealth{
+					Up:        h.Up,
+					LatencyMs: float64(h.Latency) / float64(time.Millisecond),
+					LastCheck: h.LastCheck,
+				}
+
+				if h.Err != nil {
+					s.Health.Error = h.Err.Error()
+				}
+			}
+
+			servers = append(servers, s)
+		}
+
+		apiWrite(w, http.StatusOK, servers)
+	case http.MethodPost:
+		var req apiServer
+		if !apiReadJSON(w, r, &req) {
+			return
+		}
+
+		if req.Name == "" || req.Addr == "" {
+			apiWriteError(w, http.StatusBadRequest, "name and address are required")
+			return
+		}
+
+		if !AddServer(req.Name, Server{
+			Addr:      req.Addr,
+			MediaPool: req.MediaPool,
+			Fallbacks: req.Fallbacks,
+		}) {
+			apiWriteError(w, http.StatusConflict, "server exists or media pool has no members")
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	default:
+		apiMethodNotAllowed(w)
+	}
+}
+
+// DELETE /api/servers/{name}
+func apiServerAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		apiMethodNotAllowed(w)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/api/servers/")
+	if !RmServer(name) {
+		apiWriteError(w, http.StatusConflict, "server is static or has players")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /api/bans
+// POST /api/bans {"address": "...", "name": "...", "duration": "24h", ...}
+func apiBans(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		bans, err := authIface.ExportBans()
+		if err != nil {
+			apiWriteError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		out := make([]apiBan, 0, len(bans))
+		for _, b := range bans {
+			if b.Expired() {
+				continue
+			}
+
+			out = append(out, apiBan{
+				Addr:    b.Addr,
+				Name:    b.Name,
+				Reason:  b.Reason,
+				Issuer:  b.Issuer,
+				Created: b.Created,
+				Expiry:  b.Expiry,
+			})
+		}
+
+		apiWrite(w, http.StatusOK, out)
+	case http.MethodPost:
+		var req apiBan
+		if !apiReadJSON(w, r, &req) {
+			return
+		}
+
+		if req.Issuer == "" {
+			req.Issuer = "api"
+		}
+
+		b := Ban{
+			Addr:   req.Addr,
+			Name:   req.Name,
+			Reason: req.Reason,
+			Issuer: req.Issuer,
+			Expiry: req.Expiry,
+		}
+
+		if req.Duration != "" {
+			d, err := time.ParseDuration(req.Duration)
+			if err != nil {
+				apiWriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			b.Expiry = time.Now().Add(d)
+		}
+
+		if err := AddBan(b); err != nil {
+			apiWriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	default:
+		apiMethodNotAllowed(w)
+	}
+}
+
+// DELETE /api/bans/{address, range or name}
+func apiUnban(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		apiMethodNotAllowed(w)
+		return
+	}
+
+	if err := Unban(strings.TrimPrefix(r.URL.Path, "/api/bans/")); err != nil {
+		apiWriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /api/config/reload
+func apiReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiMethodNotAllowed(w)
+		return
+	}
+
+	if err := LoadConfig(); err != nil {
+		apiWriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
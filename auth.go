@@ -1,44 +1,155 @@
 package proxy
 
 import (
+	"crypto/subtle"
 	"errors"
+	"fmt"
 	"net"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/HimbeerserverDE/srp"
 )
 
-var authIface authBackend
+var authIface AuthBackend
 var ErrAuthBackendExists = errors.New("auth backend already set")
 
-type user struct {
-	name      string
-	salt      []byte
-	verifier  []byte
-	timestamp time.Time
+// A User holds the authentication data of a player.
+type User struct {
+	Name      string
+	Salt      []byte
+	Verifier  []byte
+	Timestamp time.Time
 }
 
-type ban struct {
-	addr string
-	name string
+// A Ban is an entry of the ban list.
+// Addr is a single network address, a CIDR range
+// or empty if only the name is banned.
+// A non-empty Name is banned regardless of the address.
+// A zero Expiry means that the ban is permanent.
+type Ban struct {
+	Addr    string
+	Name    string
+	Reason  string
+	Issuer  string
+	Created time.Time
+	Expiry  time.Time
 }
 
-type authBackend interface {
+// An AuthBackend stores authentication data and ban entries.
+// Plugins can provide their own implementations
+// using RegisterAuthBackend.
+// Banned must return an entry for which Ban.Matches is true
+// and ignore expired entries.
+type AuthBackend interface {
 	Exists(name string) bool
 	Passwd(name string) (salt, verifier []byte, err error)
 	SetPasswd(name string, salt, verifier []byte) error
 	LastSrv(name string) (string, error)
 	SetLastSrv(name, srv string) error
 	Timestamp(name string) (time.Time, error)
-	Import(in []user)
-	Export() ([]user, error)
+	Import(in []User)
+	Export() ([]User, error)
 
-	Ban(addr, name string) error
+	Ban(b Ban) error
 	Unban(id string) error
-	Banned(addr *net.UDPAddr) bool
-	ImportBans(in []ban)
-	ExportBans() ([]ban, error)
+	Banned(addr *net.UDPAddr, name string) (Ban, bool)
+	ImportBans(in []Ban)
+	ExportBans() ([]Ban, error)
+}
+
+// An AuthBackendFactory creates the AuthBackend
+// selected by the AuthBackend config field.
+type AuthBackendFactory func() (AuthBackend, error)
+
+var authBackends map[string]AuthBackendFactory
+var authBackendsMu sync.RWMutex
+var authBackendsOnce sync.Once
+
+// RegisterAuthBackend makes an AuthBackend available under the
+// specified name. The factory is only called if the name is selected
+// in the configuration file. It returns true on success and false
+// if a backend with the same name already exists.
+func RegisterAuthBackend(name string, factory AuthBackendFactory) bool {
+	initAuthBackends()
+
+	authBackendsMu.Lock()
+	defer authBackendsMu.Unlock()
+
+	if _, ok := authBackends[name]; ok {
+		return false
+	}
+
+	authBackends[name] = factory
+	return true
+}
+
+func newAuthBackend(name string) (AuthBackend, error) {
+	initAuthBackends()
+
+	authBackendsMu.RLock()
+	factory, ok := authBackends[name]
+	authBackendsMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("invalid auth backend %s", name)
+	}
+
+	return factory()
+}
+
+func initAuthBackends() {
+	authBackendsOnce.Do(func() {
+		authBackendsMu.Lock()
+		defer authBackendsMu.Unlock()
+
+		authBackends = map[string]AuthBackendFactory{
+			"files": func() (AuthBackend, error) {
+				return authFiles{}, nil
+			},
+			"sqlite": func() (AuthBackend, error) {
+				return newAuthSQLite3()
+			},
+		}
+	})
+}
+
+// checkPasswd reports whether a plaintext password matches
+// the SRP verifier of a user. Users with empty passwords
+// are always rejected.
+func checkPasswd(name, passwd string) bool {
+	if passwd == "" || !validPlayerName(name) {
+		return false
+	}
+
+	salt, verifier, err := authIface.Passwd(name)
+	if err != nil {
+		return false
+	}
+
+	// Perform both sides of an SRP handshake
+	// and compare the session keys.
+	A, a, err := srp.InitiateHandshake()
+	if err != nil {
+		return false
+	}
+
+	B, _, srvK, err := srp.Handshake(A, verifier)
+	if err != nil {
+		return false
+	}
+
+	id := strings.ToLower(name)
+	cltK, err := srp.CompleteHandshake(A, a, []byte(id), []byte(passwd), salt, B)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(srvK, cltK) == 1
 }
 
-func setAuthBackend(ab authBackend) error {
+func setAuthBackend(ab AuthBackend) error {
 	if authIface != nil {
 		return ErrAuthBackendExists
 	}
@@ -1,8 +1,11 @@
 package proxy
 
 import (
+	"fmt"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,19 +21,26 @@ func (a authFiles) Exists(name string) bool {
 
 // Passwd returns the SRP salt and verifier of a user or an error.
 func (a authFiles) Passwd(name string) (salt, verifier []byte, err error) {
-	os.Mkdir(Path("auth"), 0700)
-
-	salt, err = os.ReadFile(Path("auth/", name, "/salt"))
+	salt, verifier, err = a.readPasswd(name)
 	if err != nil {
 		return
 	}
 
-	verifier, err = os.ReadFile(Path("auth/", name, "/verifier"))
+	a.updateTimestamp(name)
+	return
+}
+
+// readPasswd returns the SRP salt and verifier of a user
+// without updating the timestamp.
+func (a authFiles) readPasswd(name string) (salt, verifier []byte, err error) {
+	os.Mkdir(Path("auth"), 0700)
+
+	salt, err = os.ReadFile(Path("auth/", name, "/salt"))
 	if err != nil {
 		return
 	}
 
-	a.updateTimestamp(name)
+	verifier, err = os.ReadFile(Path("auth/", name, "/verifier"))
 	return
 }
 
@@ -83,33 +93,33 @@ func (a authFiles) Timestamp(name string) (time.Time, error) {
 }
 
 // Import deletes all users and adds the passed users.
-func (a authFiles) Import(in []user) {
+func (a authFiles) Import(in []User) {
 	os.Mkdir(Path("auth"), 0700)
 
 	for _, u := range in {
-		a.SetPasswd(u.name, u.salt, u.verifier)
-		os.Chtimes(Path("auth/", u.name, "/timestamp"), u.timestamp, u.timestamp)
+		a.SetPasswd(u.Name, u.Salt, u.Verifier)
+		os.Chtimes(Path("auth/", u.Name, "/timestamp"), u.Timestamp, u.Timestamp)
 	}
 }
 
 // Export returns data that can be processed by Import
 // or an error.
-func (a authFiles) Export() ([]user, error) {
+func (a authFiles) Export() ([]User, error) {
 	dir, err := os.ReadDir(Path("auth"))
 	if err != nil {
 		return nil, err
 	}
 
-	var out []user
+	var out []User
 	for _, f := range dir {
-		u := user{name: f.Name()}
+		u := User{Name: f.Name()}
 
-		u.timestamp, err = a.Timestamp(u.name)
+		u.Timestamp, err = a.Timestamp(u.Name)
 		if err != nil {
 			return nil, err
 		}
 
-		u.salt, u.verifier, err = a.Passwd(u.name)
+		u.Salt, u.Verifier, err = a.readPasswd(u.Name)
 		if err != nil {
 			return nil, err
 		}
@@ -120,33 +130,45 @@ func (a authFiles) Export() ([]user, error) {
 	return out, nil
 }
 
-// Ban adds a ban entry for a network address and an associated name.
-func (a authFiles) Ban(addr, name string) error {
+// Ban adds a ban entry for a network address or range
+// and an associated name.
+func (a authFiles) Ban(b Ban) error {
 	os.Mkdir(Path("ban"), 0700)
-	return os.WriteFile(Path("ban/", addr), []byte(name), 0600)
+
+	if err := os.WriteFile(Path("ban/", banFileName(b)), encodeBanFile(b), 0600); err != nil {
+		return err
+	}
+
+	// Replace the entry from before bans were stored per name.
+	if b.Addr != "" {
+		legacy := strings.Replace(b.Addr, "/", "_", 1)
+		if old, err := a.readBan(legacy); err == nil && old.Name == b.Name {
+			return os.Remove(Path("ban/", legacy))
+		}
+	}
+
+	return nil
 }
 
-// Unban deletes a ban entry. It accepts both network addresses
-// and player names.
+// Unban deletes all ban entries matching an id. It accepts
+// network addresses, ranges and player names.
 func (a authFiles) Unban(id string) error {
 	os.Mkdir(Path("ban"), 0700)
 
-	if err := os.Remove(Path("ban/", id)); err != nil {
-		if os.IsNotExist(err) {
-			dir, err := os.ReadDir(Path("ban"))
-			if err != nil {
-				return err
-			}
+	dir, err := os.ReadDir(Path("ban"))
+	if err != nil {
+		return err
+	}
 
-			for _, f := range dir {
-				name, err := os.ReadFile(Path("ban/", f.Name()))
-				if err != nil {
-					return err
-				}
+	for _, f := range dir {
+		b, err := a.readBan(f.Name())
+		if err != nil {
+			return err
+		}
 
-				if string(name) == id {
-					return os.Remove(Path("ban/", f.Name()))
-				}
+		if b.Addr == id || b.Name == id {
+			if err := os.Remove(Path("ban/", f.Name())); err != nil {
+				return err
 			}
 		}
 	}
@@ -154,30 +176,52 @@ func (a authFiles) Unban(id string) error {
 	return nil
 }
 
-// Banned reports whether a network address is banned.
-func (a authFiles) Banned(addr *net.UDPAddr) bool {
+// Banned reports whether a network address or name is banned
+// and returns the ban entry if it is. Expired entries are deleted.
+func (a authFiles) Banned(addr *net.UDPAddr, name string) (Ban, bool) {
 	os.Mkdir(Path("ban"), 0700)
 
-	_, err := os.Stat(Path("ban/", addr.IP.String()))
-	if os.IsNotExist(err) {
-		return false
+	// Fast path for exact address bans
+	if b, err := a.readBan(banFileName(Ban{Addr: addr.IP.String(), Name: name})); err == nil && !b.Expired() {
+		return b, true
 	}
 
-	return true
+	dir, err := os.ReadDir(Path("ban"))
+	if err != nil {
+		return Ban{}, false
+	}
+
+	for _, f := range dir {
+		b, err := a.readBan(f.Name())
+		if err != nil {
+			continue
+		}
+
+		if b.Expired() {
+			os.Remove(Path("ban/", f.Name()))
+			continue
+		}
+
+		if b.Matches(addr, name) {
+			return b, true
+		}
+	}
+
+	return Ban{}, false
 }
 
 // ImportBans deletes all ban entries and adds the passed entries.
-func (a authFiles) ImportBans(in []ban) {
+func (a authFiles) ImportBans(in []Ban) {
 	os.Mkdir(Path("ban"), 0700)
 
 	for _, b := range in {
-		a.Ban(b.addr, b.name)
+		a.Ban(b)
 	}
 }
 
 // ExportBans returns data that can be processed by ImportBans
 // or an error,
-func (a authFiles) ExportBans() ([]ban, error) {
+func (a authFiles) ExportBans() ([]Ban, error) {
 	os.Mkdir(Path("ban"), 0700)
 
 	dir, err := os.ReadDir(Path("ban"))
@@ -185,22 +229,81 @@ func (a authFiles) ExportBans() ([]ban, error) {
 		return nil, err
 	}
 
-	var out []ban
+	var out []Ban
 	for _, f := range dir {
-		b := ban{addr: f.Name()}
-
-		name, err := os.ReadFile(Path("ban/", f.Name()))
+		b, err := a.readBan(f.Name())
 		if err != nil {
 			return nil, err
 		}
 
-		b.name = string(name)
 		out = append(out, b)
 	}
 
 	return out, nil
 }
 
+func (a authFiles) readBan(fileName string) (Ban, error) {
+	data, err := os.ReadFile(Path("ban/", fileName))
+	if err != nil {
+		return Ban{}, err
+	}
+
+	return decodeBanFile(banFileAddr(fileName), data), nil
+}
+
+// banFileName returns the name of the file a Ban is stored in.
+// Every address and name pair is stored as "addr@name"
+// so that an address can be banned with several names.
+// File names can't contain slashes, so they're replaced
+// with underscores in ranges. Name bans don't have an address
+// and are stored as "@name". Files created before names
+// were included only consist of the address.
+func banFileName(b Ban) string {
+	return strings.Replace(b.Addr, "/", "_", 1) + "@" + b.Name
+}
+
+func banFileAddr(fileName string) string {
+	addr, _, _ := strings.Cut(fileName, "@")
+	return strings.Replace(addr, "_", "/", 1)
+}
+
+// A ban file contains the name, issuer, creation and expiry
+// unix timestamps and reason of the ban on separate lines.
+// Files that only contain a name are permanent bans
+// without any further information.
+func encodeBanFile(b Ban) []byte {
+	var created, expiry int64
+	if !b.Created.IsZero() {
+		created = b.Created.Unix()
+	}
+	if !b.Expiry.IsZero() {
+		expiry = b.Expiry.Unix()
+	}
+
+	return []byte(fmt.Sprintf("%s\n%s\n%d\n%d\n%s", b.Name, b.Issuer, created, expiry, b.Reason))
+}
+
+func decodeBanFile(addr string, data []byte) Ban {
+	b := Ban{Addr: addr}
+
+	lines := strings.SplitN(string(data), "\n", 5)
+	b.Name = lines[0]
+	if len(lines) < 5 {
+		return b
+	}
+
+	b.Issuer = lines[1]
+	if created, err := strconv.ParseInt(lines[2], 10, 64); err == nil && created != 0 {
+		b.Created = time.Unix(created, 0)
+	}
+	if expiry, err := strconv.ParseInt(lines[3], 10, 64); err == nil && expiry != 0 {
+		b.Expiry = time.Unix(expiry, 0)
+	}
+	b.Reason = lines[4]
+
+	return b
+}
+
 func (a authFiles) updateTimestamp(name string) {
 	os.Mkdir(Path("auth"), 0700)
 
@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+)
+
+// MigrateAuth copies all users, their last servers and all bans
+// from one AuthBackend to another. The names are the same ones
+// accepted by the AuthBackend config field. Existing entries
+// of the destination backend are overwritten.
+// It returns an error if the destination doesn't contain
+// every entry of the source after the migration.
+// The proxy must not be running while this function is called.
+func MigrateAuth(from, to string) error {
+	if from == to {
+		return fmt.Errorf("source and destination are both %s", from)
+	}
+
+	if !Conf().NoPlugins {
+		loadPlugins()
+	}
+
+	src, err := newAuthBackend(from)
+	if err != nil {
+		return err
+	}
+
+	dst, err := newAuthBackend(to)
+	if err != nil {
+		return err
+	}
+
+	users, err := src.Export()
+	if err != nil {
+		return err
+	}
+
+	dst.Import(users)
+
+	lastSrvs := make(map[string]string)
+	for _, u := range users {
+		srv, err := src.LastSrv(u.Name)
+		if err != nil || srv == "" {
+			continue
+		}
+
+		if err := dst.SetLastSrv(u.Name, srv); err != nil {
+			return err
+		}
+
+		lastSrvs[u.Name] = srv
+	}
+
+	log.Println("migrate users", len(users))
+
+	bans, err := src.ExportBans()
+	if err != nil {
+		return err
+	}
+
+	dst.ImportBans(bans)
+
+	log.Println("migrate bans", len(bans))
+
+	return verifyMigration(users, lastSrvs, bans, dst)
+}
+
+// verifyMigration checks that the destination contains
+// the passed users, last servers and bans. Passwd isn't used
+// because it updates the timestamps of the users.
+func verifyMigration(users []User, lastSrvs map[string]string, bans []Ban, dst AuthBackend) error {
+	dstUsers, err := dst.Export()
+	if err != nil {
+		return err
+	}
+
+	if len(dstUsers) < len(users) {
+		return fmt.Errorf("user count mismatch: have %d, want at least %d", len(dstUsers), len(users))
+	}
+
+	byName := make(map[string]User, len(dstUsers))
+	for _, u := range dstUsers {
+		byName[u.Name] = u
+	}
+
+	for _, u := range users {
+		du, ok := byName[u.Name]
+		if !ok {
+			return fmt.Errorf("user %s missing after migration", u.Name)
+		}
+
+		if !bytes.Equal(du.Salt, u.Salt) || !bytes.Equal(du.Verifier, u.Verifier) {
+			return fmt.Errorf("password of user %s differs after migration", u.Name)
+		}
+
+		// Not all backends store timestamps more precisely than one second.
+		if du.Timestamp.Unix() != u.Timestamp.Unix() {
+			return fmt.Errorf("timestamp of user %s differs after migration", u.Name)
+		}
+
+		srv, err := dst.LastSrv(u.Name)
+		if err != nil && lastSrvs[u.Name] != "" {
+			return err
+		}
+
+		if srv != lastSrvs[u.Name] {
+			return fmt.Errorf("last server of user %s differs after migration", u.Name)
+		}
+	}
+
+	dstBans, err := dst.ExportBans()
+	if err != nil {
+		return err
+	}
+
+	if len(dstBans) < len(bans) {
+		return fmt.Errorf("ban count mismatch: have %d, want at least %d", len(dstBans), len(bans))
+	}
+
+	have := make(map[banKey]struct{}, len(dstBans))
+	for _, b := range dstBans {
+		have[newBanKey(b)] = struct{}{}
+	}
+
+	for _, b := range bans {
+		if _, ok := have[newBanKey(b)]; !ok {
+			return fmt.Errorf("ban of %s %s missing or altered after migration", b.Name, b.Addr)
+		}
+	}
+
+	return nil
+}
+
+// A banKey identifies a Ban for comparison purposes.
+// Times are compared with a precision of one second
+// since not all backends store them more precisely.
+type banKey struct {
+	addr, name, reason, issuer string
+	created, expiry            int64
+}
+
+func newBanKey(b Ban) banKey {
+	k := banKey{
+		addr:   b.Addr,
+		name:   b.Name,
+		reason: b.Reason,
+		issuer: b.Issuer,
+	}
+
+	if !b.Created.IsZero() {
+		k.created = b.Created.Unix()
+	}
+
+	if !b.Expiry.IsZero() {
+		k.expiry = b.Expiry.Unix()
+	}
+
+	return k
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ErrNotSRP is returned if a Minetest password entry
+// doesn't contain an SRP salt and verifier.
+var ErrNotSRP = errors.New("password is not in SRP format")
+
+func openMinetestAuth(path string) (*sql.DB, error) {
+	return sql.Open("sqlite3", "file:"+path+"?mode=ro")
+}
+
+// decodeMinetestPasswd parses a password string of the form
+// "#1#<base64 salt>#<base64 verifier>" as stored by Minetest.
+func decodeMinetestPasswd(passwd string) (salt, verifier []byte, err error) {
+	fields := strings.Split(passwd, "#")
+	if len(fields) != 4 || fields[0] != "" || fields[1] != "1" {
+		return nil, nil, ErrNotSRP
+	}
+
+	// Padding is optional.
+	salt, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(fields[2], "="))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	verifier, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(fields[3], "="))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return salt, verifier, nil
+}
+
+// MinetestUsers reads all accounts from a Minetest auth.sqlite
+// database. Accounts that don't use SRP are skipped.
+func MinetestUsers(path string) ([]User, error) {
+	db, err := openMinetestAuth(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT name, password, last_login FROM auth;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []User
+	for rows.Next() {
+		var name, passwd string
+		var lastLogin int64
+
+		if err := rows.Scan(&name, &passwd, &lastLogin); err != nil {
+			return nil, err
+		}
+
+		salt, verifier, err := decodeMinetestPasswd(passwd)
+		if err != nil {
+			log.Print("skip minetest user ", name, ": ", err)
+			continue
+		}
+
+		out = append(out, User{
+			Name:      name,
+			Salt:      salt,
+			Verifier:  verifier,
+			Timestamp: time.Unix(lastLogin, 0),
+		})
+	}
+
+	return out, rows.Err()
+}
+
+// ImportMinetestAuth imports all SRP accounts from a Minetest
+// auth.sqlite database into the specified auth backend.
+// Existing users of the backend are overwritten.
+// It returns the number of imported users.
+// The proxy must not be running while this function is called.
+func ImportMinetestAuth(path, to string) (int, error) {
+	if !Conf().NoPlugins {
+		loadPlugins()
+	}
+
+	dst, err := newAuthBackend(to)
+	if err != nil {
+		return 0, err
+	}
+
+	users, err := MinetestUsers(path)
+	if err != nil {
+		return 0, err
+	}
+
+	dst.Import(users)
+
+	for _, u := range users {
+		if !dst.Exists(u.Name) {
+			return 0, fmt.Errorf("user %s missing after import", u.Name)
+		}
+	}
+
+	return len(users), nil
+}
+
+// importMinetestUser copies a single user from the Minetest
+// auth database configured in MinetestAuthDB to the active
+// auth backend. It returns true if the user was imported.
+func importMinetestUser(name string) bool {
+	path := Conf().MinetestAuthDB
+	if path == "" {
+		return false
+	}
+
+	db, err := openMinetestAuth(path)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	defer db.Close()
+
+	var passwd string
+	var lastLogin int64
+
+	err = db.QueryRow("SELECT password, last_login FROM auth WHERE name = ?;", name).Scan(&passwd, &lastLogin)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Print(err)
+		}
+
+		return false
+	}
+
+	salt, verifier, err := decodeMinetestPasswd(passwd)
+	if err != nil {
+		return false
+	}
+
+	authIface.Import([]User{{
+		Name:      name,
+		Salt:      salt,
+		Verifier:  verifier,
+		Timestamp: time.Unix(lastLogin, 0),
+	}})
+
+	return authIface.Exists(name)
+}
@@ -0,0 +1,402 @@
+package proxy
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type authSQLite3 struct {
+	db *sql.DB
+}
+
+// sqlExecer is implemented by both *sql.DB and *sql.Tx.
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// sqlScanner is implemented by both *sql.Row and *sql.Rows.
+type sqlScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func newAuthSQLite3() (authSQLite3, error) {
+	db, err := sql.Open("sqlite3", Path("auth.sqlite"))
+	if err != nil {
+		return authSQLite3{}, err
+	}
+
+	// SQLite only supports one writer at a time.
+	db.SetMaxOpenConns(1)
+
+	a := authSQLite3{db: db}
+	if err := a.init(); err != nil {
+		db.Close()
+		return authSQLite3{}, err
+	}
+
+	return a, nil
+}
+
+// sqliteMigrations upgrade the database schema step by step.
+// The schema version is stored in the user_version pragma.
+// Databases created before versioning was introduced have version 0
+// but may already contain some of the changes, so the steps
+// check the actual table layout.
+var sqliteMigrations = []func(tx *sql.Tx) error{
+	// 1: initial schema
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS user (
+	name TEXT PRIMARY KEY NOT NULL,
+	salt BLOB NOT NULL,
+	verifier BLOB NOT NULL,
+	last_server TEXT NOT NULL DEFAULT '',
+	timestamp INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS ban (
+	addr TEXT PRIMARY KEY NOT NULL,
+	name TEXT NOT NULL
+);`)
+		return err
+	},
+	// 2: ban reason, issuer, creation and expiry time
+	func(tx *sql.Tx) error {
+		cols, err := sqliteColumns(tx, "ban")
+		if err != nil {
+			return err
+		}
+
+		for _, col := range []string{"reason TEXT NOT NULL DEFAULT ''", "issuer TEXT NOT NULL DEFAULT ''", "created INTEGER NOT NULL DEFAULT 0", "expiry INTEGER NOT NULL DEFAULT 0"} {
+			if _, ok := cols[strings.Fields(col)[0]]; ok {
+				continue
+			}
+
+			if _, err := tx.Exec("ALTER TABLE ban ADD COLUMN " + col + ";"); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+	// 3: multiple bans per address
+	func(tx *sql.Tx) error {
+		cols, err := sqliteColumns(tx, "ban")
+		if err != nil {
+			return err
+		}
+
+		if _, ok := cols["id"]; !ok {
+			_, err := tx.Exec(`CREATE TABLE ban_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	addr TEXT NOT NULL,
+	name TEXT NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	issuer TEXT NOT NULL DEFAULT '',
+	created INTEGER NOT NULL DEFAULT 0,
+	expiry INTEGER NOT NULL DEFAULT 0,
+	UNIQUE (addr, name)
+);
+INSERT INTO ban_new (addr, name, reason, issuer, created, expiry)
+	SELECT addr, name, reason, issuer, created, expiry FROM ban;
+DROP TABLE ban;
+ALTER TABLE ban_new RENAME TO ban;`)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec("CREATE INDEX IF NOT EXISTS ban_name ON ban (name);")
+		return err
+	},
+}
+
+func (a authSQLite3) init() error {
+	var version int
+	if err := a.db.QueryRow("PRAGMA user_version;").Scan(&version); err != nil {
+		return err
+	}
+
+	if version >= len(sqliteMigrations) {
+		return nil
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		if err := sqliteMigrations[i](tx); err != nil {
+			return fmt.Errorf("migrate auth database to version %d: %w", i+1, err)
+		}
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d;", len(sqliteMigrations))); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Println("migrate auth database from version", version, "to", len(sqliteMigrations))
+	return nil
+}
+
+// sqliteColumns returns the names of the columns of a table.
+func sqliteColumns(tx *sql.Tx, table string) (map[string]struct{}, error) {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?);", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols := make(map[string]struct{})
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		cols[name] = struct{}{}
+	}
+
+	return cols, rows.Err()
+}
+
+// Exists reports whether a user is registered.
+func (a authSQLite3) Exists(name string) bool {
+	var n int
+	err := a.db.QueryRow("SELECT 1 FROM user WHERE name = ?;", name).Scan(&n)
+	return err == nil
+}
+
+// Passwd returns the SRP salt and verifier of a user or an error.
+func (a authSQLite3) Passwd(name string) (salt, verifier []byte, err error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("SELECT salt, verifier FROM user WHERE name = ?;", name).Scan(&salt, &verifier)
+	if err != nil {
+		return
+	}
+
+	if err = a.updateTimestamp(tx, name); err != nil {
+		return
+	}
+
+	err = tx.Commit()
+	return
+}
+
+// SetPasswd creates a password entry if necessary
+// and sets the password of a user.
+func (a authSQLite3) SetPasswd(name string, salt, verifier []byte) error {
+	_, err := a.db.Exec(`INSERT INTO user (name, salt, verifier, timestamp) VALUES (?, ?, ?, ?)
+ON CONFLICT (name) DO UPDATE SET salt = excluded.salt, verifier = excluded.verifier, timestamp = excluded.timestamp;`,
+		name, salt, verifier, time.Now().Unix())
+	return err
+}
+
+// LastSrv returns the last server a user was on.
+func (a authSQLite3) LastSrv(name string) (string, error) {
+	var srv string
+	err := a.db.QueryRow("SELECT last_server FROM user WHERE name = ?;", name).Scan(&srv)
+	return srv, err
+}
+
+// SetLastSrv sets the last server a user was on.
+func (a authSQLite3) SetLastSrv(name, srv string) error {
+	_, err := a.db.Exec("UPDATE user SET last_server = ? WHERE name = ?;", srv, name)
+	return err
+}
+
+// Timestamp returns the last time an authentication entry was accessed
+// or an error.
+func (a authSQLite3) Timestamp(name string) (time.Time, error) {
+	var ts int64
+	if err := a.db.QueryRow("SELECT timestamp FROM user WHERE name = ?;", name).Scan(&ts); err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(ts, 0), nil
+}
+
+// Import adds the passed users, overwriting existing entries
+// with the same name. All users are added in a single transaction.
+func (a authSQLite3) Import(in []User) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	for _, u := range in {
+		_, err := tx.Exec(`INSERT INTO user (name, salt, verifier, timestamp) VALUES (?, ?, ?, ?)
+ON CONFLICT (name) DO UPDATE SET salt = excluded.salt, verifier = excluded.verifier, timestamp = excluded.timestamp;`,
+			u.Name, u.Salt, u.Verifier, u.Timestamp.Unix())
+		if err != nil {
+			return
+		}
+	}
+
+	tx.Commit()
+}
+
+// Export returns data that can be processed by Import
+// or an error.
+func (a authSQLite3) Export() ([]User, error) {
+	rows, err := a.db.Query("SELECT name, salt, verifier, timestamp FROM user;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []User
+	for rows.Next() {
+		var u User
+		var ts int64
+
+		if err := rows.Scan(&u.Name, &u.Salt, &u.Verifier, &ts); err != nil {
+			return nil, err
+		}
+
+		u.Timestamp = time.Unix(ts, 0)
+		out = append(out, u)
+	}
+
+	return out, rows.Err()
+}
+
+// Ban adds a ban entry for a network address or range
+// and an associated name.
+func (a authSQLite3) Ban(b Ban) error {
+	return a.insertBan(a.db, b)
+}
+
+// Unban deletes all ban entries matching an id. It accepts
+// network addresses, ranges and player names.
+func (a authSQLite3) Unban(id string) error {
+	_, err := a.db.Exec("DELETE FROM ban WHERE addr = ? OR name = ?;", id, id)
+	return err
+}
+
+// Banned reports whether a network address or name is banned
+// and returns the ban entry if it is. Expired entries are deleted.
+func (a authSQLite3) Banned(addr *net.UDPAddr, name string) (Ban, bool) {
+	if _, err := a.db.Exec("DELETE FROM ban WHERE expiry != 0 AND expiry <= ?;", time.Now().Unix()); err != nil {
+		log.Print("delete expired bans: ", err)
+	}
+
+	rows, err := a.db.Query(`SELECT addr, name, reason, issuer, created, expiry FROM ban
+WHERE addr = ? OR (name != '' AND name = ?) OR addr LIKE '%/%';`, addr.IP.String(), name)
+	if err != nil {
+		log.Print("query bans: ", err)
+		return Ban{}, false
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		b, err := scanBan(rows)
+		if err != nil {
+			log.Print("query bans: ", err)
+			return Ban{}, false
+		}
+
+		if b.Matches(addr, name) {
+			return b, true
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Print("query bans: ", err)
+	}
+
+	return Ban{}, false
+}
+
+// ImportBans adds the passed entries, overwriting existing entries
+// with the same address and name. All entries are added in a single transaction.
+func (a authSQLite3) ImportBans(in []Ban) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	for _, b := range in {
+		if err := a.insertBan(tx, b); err != nil {
+			return
+		}
+	}
+
+	tx.Commit()
+}
+
+// ExportBans returns data that can be processed by ImportBans
+// or an error.
+func (a authSQLite3) ExportBans() ([]Ban, error) {
+	rows, err := a.db.Query("SELECT addr, name, reason, issuer, created, expiry FROM ban;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Ban
+	for rows.Next() {
+		b, err := scanBan(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, b)
+	}
+
+	return out, rows.Err()
+}
+
+func (a authSQLite3) insertBan(db sqlExecer, b Ban) error {
+	var created, expiry int64
+	if !b.Created.IsZero() {
+		created = b.Created.Unix()
+	}
+	if !b.Expiry.IsZero() {
+		expiry = b.Expiry.Unix()
+	}
+
+	_, err := db.Exec("REPLACE INTO ban (addr, name, reason, issuer, created, expiry) VALUES (?, ?, ?, ?, ?, ?);",
+		b.Addr, b.Name, b.Reason, b.Issuer, created, expiry)
+	return err
+}
+
+func scanBan(row sqlScanner) (Ban, error) {
+	var b Ban
+	var created, expiry int64
+
+	if err := row.Scan(&b.Addr, &b.Name, &b.Reason, &b.Issuer, &created, &expiry); err != nil {
+		return Ban{}, err
+	}
+
+	if created != 0 {
+		b.Created = time.Unix(created, 0)
+	}
+	if expiry != 0 {
+		b.Expiry = time.Unix(expiry, 0)
+	}
+
+	return b, nil
+}
+
+func (a authSQLite3) updateTimestamp(tx *sql.Tx, name string) error {
+	_, err := tx.Exec("UPDATE user SET timestamp = ? WHERE name = ?;", time.Now().Unix(), name)
+	return err
+}
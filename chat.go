@@ -2,7 +2,6 @@ package proxy
 
 import (
 	"fmt"
-	"io"
 	"strings"
 	"time"
 
@@ -24,7 +23,14 @@ func (cc *ClientConn) DoChatMsg(msg string) {
 	}
 
 	if !isCmd {
+		msg, ok := handleChatMsg(cc, cmd.Msg)
+		if !ok {
+			return
+		}
+
+		cmd.Msg = msg
 		cc.server().SendCmd(cmd)
+		globalChatMsg(cc, msg)
 	}
 }
 
@@ -85,7 +91,61 @@ func onChatMsg(cc *ClientConn, cmd *mt.ToSrvChatMsg) (string, bool) {
 	return "", false
 }
 
-func onTelnetMsg(tlog func(dir string, v ...interface{}), w io.Writer, msg string) string {
+// globalChatMsg sends a chat message of the ClientConn
+// to the players on other servers if global chat is enabled.
+// If GlobalChat.Groups is set, the message is only sent to players
+// on servers that share one of these groups with the sender.
+func globalChatMsg(cc *ClientConn, msg string) {
+	conf := Conf()
+	if !conf.GlobalChat.Enable {
+		return
+	}
+
+	srv := cc.ServerName()
+	if srv == "" {
+		return
+	}
+
+	// A nil map means that all servers receive the message.
+	var targets map[string]struct{}
+	if len(conf.GlobalChat.Groups) > 0 {
+		targets = make(map[string]struct{})
+		for _, name := range conf.GlobalChat.Groups {
+			grp := conf.ServerGroups[name]
+			if !containsString(grp.Members, srv) {
+				continue
+			}
+
+			for _, member := range grp.Members {
+				targets[member] = struct{}{}
+			}
+		}
+
+		if len(targets) == 0 {
+			return
+		}
+	}
+
+	text := fmt.Sprintf("[%s] <%s> %s", srv, cc.Name(), msg)
+	for clt := range Clts() {
+		name := clt.ServerName()
+		if name == "" || name == srv || clt.state() < csActive {
+			continue
+		}
+
+		if _, ok := targets[name]; targets != nil && !ok {
+			continue
+		}
+
+		clt.SendCmd(&mt.ToCltChatMsg{
+			Type:      mt.RawMsg,
+			Text:      text,
+			Timestamp: time.Now().Unix(),
+		})
+	}
+}
+
+func onTelnetMsg(tlog func(dir string, v ...interface{}), w *TelnetWriter, msg string) string {
 	initChatCmds()
 
 	substrs := strings.Split(msg, " ")
@@ -105,5 +165,11 @@ func onTelnetMsg(tlog func(dir string, v ...interface{}), w io.Writer, msg strin
 	defer chatCmdsMu.RUnlock()
 
 	cmd := chatCmds[cmdName]
+
+	if !w.HasPerms(cmd.Perm) {
+		tlog("<-", "deny command", cmdName)
+		return fmt.Sprintf("Missing permission %s.\n", cmd.Perm)
+	}
+
 	return cmd.Handler(nil, w, args...) + "\n"
 }
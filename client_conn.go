@@ -58,6 +58,11 @@ type ClientConn struct {
 
 	modChs   map[string]struct{}
 	modChsMu sync.RWMutex
+
+	formspecs    map[string]FormspecHandler
+	formspecsMu  sync.Mutex
+	formspecCh   chan func()
+	formspecOnce sync.Once
 }
 
 // Name returns the player name of the ClientConn.
@@ -120,6 +125,14 @@ func handleClt(cc *ClientConn) {
 					playersMu.Lock()
 					delete(players, cc.Name())
 					playersMu.Unlock()
+
+					cc.dequeue()
+					cc.unpark()
+					signalQueue()
+				}
+
+				if cc.state() >= csActive {
+					fireLeave(cc)
 				}
 
 				if cc.server() != nil {
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	proxy "github.com/HimbeerserverDE/mt-multiserver-proxy"
+)
+
+func importMinetestAuth(args []string) {
+	fs := flag.NewFlagSet("import-minetest-auth", flag.ExitOnError)
+	db := fs.String("db", "", "the path to the auth.sqlite of the Minetest world")
+	to := fs.String("to", "", "the auth backend to write to")
+	fs.Parse(args)
+
+	if *db == "" || *to == "" {
+		fs.Usage()
+		log.Fatal("both -db and -to are required")
+	}
+
+	n, err := proxy.ImportMinetestAuth(*db, *to)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("import minetest users", n)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	proxy "github.com/HimbeerserverDE/mt-multiserver-proxy"
+)
+
+func migrateAuth(args []string) {
+	fs := flag.NewFlagSet("migrate-auth", flag.ExitOnError)
+	from := fs.String("from", "", "the auth backend to read from")
+	to := fs.String("to", "", "the auth backend to write to")
+	fs.Parse(args)
+
+	if *from == "" || *to == "" {
+		fs.Usage()
+		log.Fatal("both -from and -to are required")
+	}
+
+	if err := proxy.MigrateAuth(*from, *to); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Print("migration complete")
+}
@@ -1,10 +1,37 @@
 /*
 mt-multiserver-proxy starts the reverse proxy.
+
+Usage:
+
+	mt-multiserver-proxy
+	mt-multiserver-proxy migrate-auth -from BACKEND -to BACKEND
+	mt-multiserver-proxy import-minetest-auth -db PATH -to BACKEND
+
+The migrate-auth subcommand copies all users and bans from one
+auth backend to another and exits. The import-minetest-auth
+subcommand copies all accounts from the auth.sqlite database
+of a Minetest server to an auth backend and exits.
+The proxy must not be running while either of them is in progress.
 */
 package main
 
-import proxy "github.com/HimbeerserverDE/mt-multiserver-proxy"
+import (
+	"os"
+
+	proxy "github.com/HimbeerserverDE/mt-multiserver-proxy"
+)
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate-auth":
+			migrateAuth(os.Args[2:])
+			return
+		case "import-minetest-auth":
+			importMinetestAuth(os.Args[2:])
+			return
+		}
+	}
+
 	proxy.Run()
 }
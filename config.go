@@ -1,32 +1,51 @@
 package proxy
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 )
 
 const (
-	defaultCmdPrefix    = ">"
-	defaultSendInterval = 0.09
-	defaultUserLimit    = 10
-	defaultAuthBackend  = "files"
-	defaultTelnetAddr   = "[::1]:40010"
-	defaultBindAddr     = ":40000"
-	defaultListInterval = 300
+	defaultCmdPrefix      = ">"
+	defaultSendInterval   = 0.09
+	defaultUserLimit      = 10
+	defaultAuthBackend    = "files"
+	defaultTelnetAddr     = "[::1]:40010"
+	defaultSSHAddr        = "[::1]:40011"
+	defaultSSHHostKey     = "ssh_host_ed25519_key"
+	defaultSSHAuthKeys    = "authorized_keys"
+	defaultAPIAddr        = "[::1]:40012"
+	defaultMetricsAddr    = "[::1]:40013"
+	defaultBindAddr       = ":40000"
+	defaultListInterval   = 300
+	defaultHealthInterval = 10
+	defaultHealthTimeout  = 3
+	defaultQueuePrioPerm  = "queue_priority"
+	defaultLimboName      = "limbo"
+	defaultLimboRetry     = 5
 )
 
 var config Config
 var configMu sync.RWMutex
+var configLoaded bool
 
 var loadConfigOnce sync.Once
 
 type Server struct {
-	Addr      string
-	MediaPool string
-	Fallbacks []string
+	Addr        string
+	MediaPool   string
+	Fallbacks   []string
+	Perm        string
+	AllowGroups []string
+	DenyGroups  []string
+	MaxPlayers  int
 
 	dynamic bool
 }
@@ -34,19 +53,57 @@ type Server struct {
 // A Config contains information from the configuration file
 // that affects the way the proxy works.
 type Config struct {
-	NoPlugins       bool
-	CmdPrefix       string
-	RequirePasswd   bool
-	SendInterval    float32
-	UserLimit       int
-	AuthBackend     string
-	NoTelnet        bool
-	TelnetAddr      string
+	NoPlugins      bool
+	CmdPrefix      string
+	RequirePasswd  bool
+	SendInterval   float32
+	UserLimit      int
+	AuthBackend    string
+	MinetestAuthDB string
+	NoTelnet       bool
+	NoTelnetAuth   bool
+	TelnetAddr     string
+	SSH            struct {
+		Enable         bool
+		Addr           string
+		HostKey        string
+		AuthorizedKeys string
+	}
+	API struct {
+		Enable bool
+		Addr   string
+		Tokens []string
+	}
+	Metrics struct {
+		Enable bool
+		Addr   string
+	}
 	BindAddr        string
 	Servers         map[string]Server
+	ServerGroups    map[string]ServerGroup
+	DefaultSrv      string
 	ForceDefaultSrv bool
 	FallbackServers []string
-	CSMRF           struct {
+	HealthCheck     struct {
+		Enable   bool
+		Interval int
+		Timeout  int
+	}
+	Queue struct {
+		Enable       bool
+		Server       string
+		PriorityPerm string
+	}
+	GlobalChat struct {
+		Enable bool
+		Groups []string
+	}
+	Limbo struct {
+		Enable        bool
+		Name          string
+		RetryInterval int
+	}
+	CSMRF struct {
 		NoCSMs          bool
 		ChatMsgs        bool
 		ItemDefs        bool
@@ -54,11 +111,14 @@ type Config struct {
 		NoLimitMapRange bool
 		PlayerList      bool
 	}
-	MapRange   uint32
-	DropCSMRF  bool
-	Groups     map[string][]string
-	UserGroups map[string]string
-	List       struct {
+	MapRange     uint32
+	DropCSMRF    bool
+	Groups       map[string][]string
+	GroupParents map[string][]string
+	UserGroups   map[string]string
+	UserPerms    map[string][]string
+	WatchConfig  bool
+	List         struct {
 		Enable   bool
 		Addr     string
 		Interval int
@@ -73,6 +133,10 @@ type Config struct {
 		FarNames bool
 		Mods     []string
 	}
+
+	// serverOrder contains the server names
+	// in the order they were defined in.
+	serverOrder []string
 }
 
 // Conf returns a copy of the Config used by the proxy.
@@ -107,6 +171,7 @@ func PoolServers() map[string]map[string]Server {
 // AddServer dynamically configures a new Server at runtime.
 // Servers added in this way are ephemeral and will be lost
 // when the proxy shuts down.
+// The name must not be used by another server or server group.
 // The server must be part of a media pool with at least one
 // other member. At least one of the other members always
 // needs to be reachable.
@@ -123,6 +188,14 @@ func AddServer(name string, s Server) bool {
 		return false
 	}
 
+	if _, ok := config.ServerGroups[name]; ok {
+		return false
+	}
+
+	if config.Limbo.Enable && name == config.Limbo.Name {
+		return false
+	}
+
 	var poolMembers bool
 	for _, srv := range config.Servers {
 		if srv.MediaPool == s.MediaPool {
@@ -135,6 +208,7 @@ func AddServer(name string, s Server) bool {
 	}
 
 	config.Servers[name] = s
+	config.serverOrder = append(config.serverOrder, name)
 	return true
 }
 
@@ -162,19 +236,87 @@ func RmServer(name string) bool {
 	}
 
 	delete(config.Servers, name)
+
+	order := make([]string, 0, len(config.serverOrder))
+	for _, srvName := range config.serverOrder {
+		if srvName != name {
+			order = append(order, srvName)
+		}
+	}
+	config.serverOrder = order
+
 	return true
 }
 
+// ServerNames returns the names of all servers in the order
+// they were defined in. Servers added using AddServer come last.
+func (cnf Config) ServerNames() []string {
+	names := make([]string, 0, len(cnf.Servers))
+	known := make(map[string]struct{}, len(cnf.Servers))
+	for _, name := range cnf.serverOrder {
+		if _, ok := known[name]; ok {
+			continue
+		}
+
+		if _, ok := cnf.Servers[name]; ok {
+			names = append(names, name)
+			known[name] = struct{}{}
+		}
+	}
+
+	// Servers added to the map by other means.
+	var unknown []string
+	for name := range cnf.Servers {
+		if _, ok := known[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+
+	return append(names, unknown...)
+}
+
 // DefaultServerInfo returns both the name of the default server
-// and information about it. The return values are uninitialized
-// if no servers exist.
+// and information about it. The default server is DefaultSrv
+// if it is set or the first server in the configuration file otherwise.
+// If it is a server group or a member of one, a member of the group
+// is picked. The return values are uninitialized if no servers exist.
 func (cnf Config) DefaultServerInfo() (string, Server) {
-	for name, srv := range Conf().Servers {
-		return name, srv
+	name := cnf.DefaultSrv
+	if name == "" {
+		names := cnf.ServerNames()
+		if len(names) == 0 {
+			// No servers are configured.
+			return "", Server{}
+		}
+
+		name = names[0]
+	}
+
+	grp := name
+	if _, ok := cnf.ServerGroups[name]; !ok {
+		grp, _ = serverGroupOf(cnf, name)
 	}
 
-	// No servers are configured.
-	return "", Server{}
+	if member, ok := resolveServer(cnf, grp); ok {
+		name = member
+	} else if grp == name {
+		// No member is available, use the first one anyway
+		// so that the caller can fall back.
+		for _, member := range cnf.ServerGroups[grp].Members {
+			if _, ok := cnf.Servers[member]; ok {
+				name = member
+				break
+			}
+		}
+	}
+
+	srv, ok := cnf.Servers[name]
+	if !ok {
+		return "", Server{}
+	}
+
+	return name, srv
 }
 
 // DefaultServerName returns the name of the default server.
@@ -194,7 +336,9 @@ func (cnf Config) DefaultServer() Server {
 }
 
 // FallbackServers returns a slice of server names that
-// a server can fall back to.
+// a server can fall back to. Server groups are resolved
+// to one of their members and servers that are known
+// to be down are skipped.
 func FallbackServers(server string) []string {
 	conf := Conf()
 
@@ -203,18 +347,27 @@ func FallbackServers(server string) []string {
 		return nil
 	}
 
-	fallbacks := srv.Fallbacks
+	fallbacks := append([]string{}, srv.Fallbacks...)
 
 	// global fallbacks
 	if len(conf.FallbackServers) == 0 {
-		if len(conf.Servers) == 0 {
-			return fallbacks
+		if len(conf.Servers) != 0 {
+			fallbacks = append(fallbacks, conf.DefaultServerName())
 		}
-
-		return append(fallbacks, conf.DefaultServerName())
 	} else {
-		return append(fallbacks, conf.FallbackServers...)
+		fallbacks = append(fallbacks, conf.FallbackServers...)
+	}
+
+	// Pick server group members and skip servers
+	// that are known to be down.
+	up := make([]string, 0, len(fallbacks))
+	for _, name := range fallbacks {
+		if srv, ok := resolveServer(conf, name); ok && serverUp(conf, srv) {
+			up = append(up, srv)
+		}
 	}
+
+	return up
 }
 
 // LoadConfig attempts to parse the configuration file.
@@ -226,15 +379,32 @@ func LoadConfig() error {
 
 	oldConf := config
 
+	// Start from scratch so that removed entries
+	// don't survive the reload.
+	config = Config{}
+	config.Servers = make(map[string]Server)
 	config.CmdPrefix = defaultCmdPrefix
 	config.SendInterval = defaultSendInterval
 	config.UserLimit = defaultUserLimit
 	config.AuthBackend = defaultAuthBackend
 	config.TelnetAddr = defaultTelnetAddr
+	config.SSH.Addr = defaultSSHAddr
+	config.SSH.HostKey = defaultSSHHostKey
+	config.SSH.AuthorizedKeys = defaultSSHAuthKeys
+	config.API.Addr = defaultAPIAddr
+	config.Metrics.Addr = defaultMetricsAddr
 	config.BindAddr = defaultBindAddr
+	config.ServerGroups = make(map[string]ServerGroup)
 	config.FallbackServers = make([]string, 0)
 	config.Groups = make(map[string][]string)
+	config.GroupParents = make(map[string][]string)
 	config.UserGroups = make(map[string]string)
+	config.UserPerms = make(map[string][]string)
+	config.HealthCheck.Interval = defaultHealthInterval
+	config.HealthCheck.Timeout = defaultHealthTimeout
+	config.Queue.PriorityPerm = defaultQueuePrioPerm
+	config.Limbo.Name = defaultLimboName
+	config.Limbo.RetryInterval = defaultLimboRetry
 	config.List.Interval = defaultListInterval
 
 	f, err := os.OpenFile(Path("config.json"), os.O_RDWR|os.O_CREATE, 0666)
@@ -249,12 +419,19 @@ func LoadConfig() error {
 		f.Seek(0, os.SEEK_SET)
 	}
 
-	decoder := json.NewDecoder(f)
-	if err := decoder.Decode(&config); err != nil {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		config = oldConf
+		return err
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
 		config = oldConf
 		return err
 	}
 
+	config.serverOrder = jsonObjectKeys(data, "Servers")
+
 	// Dynamic servers shouldn't be deleted silently.
 	for name, srv := range oldConf.Servers {
 		if srv.dynamic {
@@ -264,6 +441,7 @@ func LoadConfig() error {
 			}
 
 			config.Servers[name] = srv
+			config.serverOrder = append(config.serverOrder, name)
 		} else {
 			if _, ok := config.Servers[name]; ok {
 				continue
@@ -278,6 +456,64 @@ func LoadConfig() error {
 		}
 	}
 
+	if config.DefaultSrv != "" {
+		_, srvOk := config.Servers[config.DefaultSrv]
+		_, grpOk := config.ServerGroups[config.DefaultSrv]
+
+		if !srvOk && !grpOk {
+			def := config.DefaultSrv
+			config = oldConf
+			return fmt.Errorf("inexistent default server %s", def)
+		}
+	}
+
+	for _, field := range []struct {
+		name string
+		v    int
+	}{
+		{"HealthCheck.Interval", config.HealthCheck.Interval},
+		{"HealthCheck.Timeout", config.HealthCheck.Timeout},
+		{"Limbo.RetryInterval", config.Limbo.RetryInterval},
+		{"List.Interval", config.List.Interval},
+	} {
+		if field.v <= 0 {
+			config = oldConf
+			return fmt.Errorf("%s must be positive", field.name)
+		}
+	}
+
+	for _, name := range config.GlobalChat.Groups {
+		if _, ok := config.ServerGroups[name]; !ok {
+			config = oldConf
+			return fmt.Errorf("inexistent global chat server group %s", name)
+		}
+	}
+
+	if config.Limbo.Enable {
+		_, srvOk := config.Servers[config.Limbo.Name]
+		_, grpOk := config.ServerGroups[config.Limbo.Name]
+
+		if srvOk || grpOk {
+			name := config.Limbo.Name
+			config = oldConf
+			return fmt.Errorf("limbo name %s is already in use", name)
+		}
+	}
+
+	for name, grp := range config.ServerGroups {
+		if _, ok := config.Servers[name]; ok {
+			config = oldConf
+			return fmt.Errorf("server group %s has the same name as a server", name)
+		}
+
+		switch grp.Balance {
+		case "", BalanceLeastPlayers, BalanceRoundRobin:
+		default:
+			config = oldConf
+			return fmt.Errorf("invalid balance %s for server group %s", grp.Balance, name)
+		}
+	}
+
 	for name, srv := range config.Servers {
 		if srv.MediaPool == "" {
 			s := config.Servers[name]
@@ -286,6 +522,69 @@ func LoadConfig() error {
 		}
 	}
 
+	if configLoaded {
+		for _, change := range configChanges(oldConf, config) {
+			log.Print("config ", change)
+		}
+
+		select {
+		case listReloadCh <- struct{}{}:
+		default:
+		}
+	}
+
+	configLoaded = true
+
 	log.Print("load config")
 	return nil
 }
+
+// jsonObjectKeys returns the keys of an object that is a field
+// of the top level JSON object in the order they appear in.
+// Field names are matched case-insensitively like encoding/json does.
+func jsonObjectKeys(data []byte, field string) []string {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil
+	}
+
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+
+		if key, _ := t.(string); !strings.EqualFold(key, field) {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil
+			}
+
+			continue
+		}
+
+		if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+			return nil
+		}
+
+		var keys []string
+		for dec.More() {
+			t, err := dec.Token()
+			if err != nil {
+				return keys
+			}
+
+			key, _ := t.(string)
+			keys = append(keys, key)
+
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return keys
+			}
+		}
+
+		return keys
+	}
+
+	return nil
+}
@@ -17,6 +17,22 @@ func connect(conn net.Conn, name string, cc *ClientConn) *ServerConn {
 	}
 	cc.mu.RUnlock()
 
+	sc := newServerConn(conn, name, cc)
+	close(sc.attachCh)
+
+	cc.mu.Lock()
+	cc.srv = sc
+	cc.mu.Unlock()
+
+	go handleSrv(sc)
+	return sc
+}
+
+// newServerConn creates a ServerConn for a ClientConn
+// without making it the active server connection of the client.
+// Its attach channel needs to be closed once it has been attached
+// or discarded.
+func newServerConn(conn net.Conn, name string, cc *ClientConn) *ServerConn {
 	var mediaPool string
 	for srvName, srv := range Conf().Servers {
 		if srvName == name {
@@ -29,6 +45,7 @@ func connect(conn net.Conn, name string, cc *ClientConn) *ServerConn {
 		Peer:             mt.Connect(conn),
 		logger:           log.New(logWriter, logPrefix, log.LstdFlags|log.Lmsgprefix),
 		initCh:           make(chan struct{}),
+		attachCh:         make(chan struct{}),
 		clt:              cc,
 		name:             name,
 		mediaPool:        mediaPool,
@@ -40,11 +57,6 @@ func connect(conn net.Conn, name string, cc *ClientConn) *ServerConn {
 	}
 	sc.Log("->", "connect")
 
-	cc.mu.Lock()
-	cc.srv = sc
-	cc.mu.Unlock()
-
-	go handleSrv(sc)
 	return sc
 }
 
@@ -10,6 +10,7 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/HimbeerserverDE/srp"
@@ -303,6 +304,8 @@ func (cc *ClientConn) sendMedia(filenames []string) {
 				bunches[len(bunches)-1] = append(bunches[len(bunches)-1], mfile)
 
 				bunchSize += len(f.data)
+				atomic.AddUint64(&metricMediaBytes, uint64(len(f.data)))
+
 				if bunchSize >= bytesPerMediaBunch {
 					bunches = append(bunches, []struct {
 						Name string
@@ -333,8 +336,9 @@ func (cc *ClientConn) sendMedia(filenames []string) {
 
 type param0Map map[string]map[mt.Content]mt.Content
 type param0SrvMap map[mt.Content]struct {
-	name   string
-	param0 mt.Content
+	name     string
+	param0   mt.Content
+	nodeName string
 }
 
 func muxItemDefs(conns []*contentConn) ([]mt.ItemDef, []struct{ Alias, Orig string }) {
@@ -391,22 +395,28 @@ func muxNodeDefs(conns []*contentConn) (nodeDefs []mt.NodeDef, p0Map param0Map,
 	p0Map = make(param0Map)
 	p0SrvMap = param0SrvMap{
 		mt.Unknown: struct {
-			name   string
-			param0 mt.Content
+			name     string
+			param0   mt.Content
+			nodeName string
 		}{
-			param0: mt.Unknown,
+			param0:   mt.Unknown,
+			nodeName: "unknown",
 		},
 		mt.Air: struct {
-			name   string
-			param0 mt.Content
+			name     string
+			param0   mt.Content
+			nodeName string
 		}{
-			param0: mt.Air,
+			param0:   mt.Air,
+			nodeName: "air",
 		},
 		mt.Ignore: struct {
-			name   string
-			param0 mt.Content
+			name     string
+			param0   mt.Content
+			nodeName string
 		}{
-			param0: mt.Ignore,
+			param0:   mt.Ignore,
+			nodeName: "ignore",
 		},
 	}
 
@@ -423,11 +433,13 @@ func muxNodeDefs(conns []*contentConn) (nodeDefs []mt.NodeDef, p0Map param0Map,
 
 			p0Map[cc.name][def.Param0] = param0
 			p0SrvMap[param0] = struct {
-				name   string
-				param0 mt.Content
+				name     string
+				param0   mt.Content
+				nodeName string
 			}{
-				name:   cc.name,
-				param0: def.Param0,
+				name:     cc.name,
+				param0:   def.Param0,
+				nodeName: def.Name,
 			}
 
 			def.Param0 = param0
@@ -497,11 +509,31 @@ func muxRemotes(conns []*contentConn) []string {
 func muxContent(userName string) (itemDefs []mt.ItemDef, aliases []struct{ Alias, Orig string }, nodeDefs []mt.NodeDef, p0Map param0Map, p0SrvMap param0SrvMap, media []mediaFile, remotes []string, err error) {
 	var conns []*contentConn
 
+	conf := Conf()
+	names := conf.ServerNames()
+
+	// Process the media pools in the order their first member
+	// was defined in so that the content is deterministic.
+	var pools []string
+	seen := make(map[string]struct{})
+	for _, name := range names {
+		pool := conf.Servers[name].MediaPool
+		if _, ok := seen[pool]; !ok {
+			pools = append(pools, pool)
+			seen[pool] = struct{}{}
+		}
+	}
+
 PoolLoop:
-	for _, pool := range PoolServers() {
+	for _, pool := range pools {
 		var addr *net.UDPAddr
 
-		for name, srv := range pool {
+		for _, name := range names {
+			srv := conf.Servers[name]
+			if srv.MediaPool != pool {
+				continue
+			}
+
 			addr, err = net.ResolveUDPAddr("udp", srv.Addr)
 			if err != nil {
 				continue
@@ -3,8 +3,21 @@ package proxy
 import (
 	"regexp"
 	"strings"
+
+	"github.com/anon55555/mt"
 )
 
+// formspecPrefix is prepended to the names of formspecs
+// shown by the proxy to make collisions with formnames
+// used by servers unlikely. Fields of formspecs with this prefix
+// are never forwarded to servers, even if the formspec is unknown.
+const formspecPrefix = "mt-multiserver-proxy:"
+
+// A FormspecHandler is called when a player submits
+// a formspec shown using ClientConn.ShowFormspec.
+// The "quit" field is set if the formspec has been closed.
+type FormspecHandler func(cc *ClientConn, fields map[string]string)
+
 var textureName = regexp.MustCompile("[a-zA-Z0-9-_.]*\\.[a-zA-Z-_.]+")
 
 func (sc *ServerConn) prependFormspec(fs *string) {
@@ -25,3 +38,87 @@ func (sc *ServerConn) prependFormspec(fs *string) {
 		}
 	}
 }
+
+// ShowFormspec shows a formspec to the ClientConn and calls
+// the callback whenever fields are submitted until the formspec
+// is closed. The submitted fields are handled by the proxy
+// and never reach the upstream server. Showing another formspec
+// with the same name replaces the callback. Callbacks of
+// a ClientConn are called one at a time in the order
+// the fields were submitted in.
+func (cc *ClientConn) ShowFormspec(name, spec string, callback FormspecHandler) {
+	cc.formspecsMu.Lock()
+	cc.formspecs[name] = callback
+	cc.formspecsMu.Unlock()
+
+	cc.SendCmd(&mt.ToCltShowFormspec{
+		Formspec: spec,
+		Formname: formspecPrefix + name,
+	})
+}
+
+// CloseFormspec closes a formspec shown using ShowFormspec
+// without calling its callback.
+func (cc *ClientConn) CloseFormspec(name string) {
+	cc.formspecsMu.Lock()
+	delete(cc.formspecs, name)
+	cc.formspecsMu.Unlock()
+
+	cc.SendCmd(&mt.ToCltShowFormspec{
+		Formname: formspecPrefix + name,
+	})
+}
+
+// handleFormspecFields passes the fields of a formspec shown
+// by the proxy to its callback. It returns false if the formname
+// doesn't belong to the proxy and the fields should be forwarded
+// to the server.
+func (cc *ClientConn) handleFormspecFields(cmd *mt.ToSrvInvFields) bool {
+	if !strings.HasPrefix(cmd.Formname, formspecPrefix) {
+		return false
+	}
+
+	name := strings.TrimPrefix(cmd.Formname, formspecPrefix)
+
+	fields := make(map[string]string, len(cmd.Fields))
+	for _, field := range cmd.Fields {
+		fields[field.Name] = field.Value
+	}
+
+	cc.formspecsMu.Lock()
+	callback, ok := cc.formspecs[name]
+	if _, quit := fields["quit"]; ok && quit {
+		delete(cc.formspecs, name)
+	}
+	cc.formspecsMu.Unlock()
+
+	if !ok {
+		cc.Log("->", "fields of unknown formspec", name)
+		return true
+	}
+
+	// Callbacks may take a while, e.g. if they hop the player,
+	// so they are called by a separate goroutine.
+	cc.formspecOnce.Do(func() {
+		cc.formspecCh = make(chan func(), 16)
+		go cc.formspecLoop()
+	})
+
+	select {
+	case cc.formspecCh <- func() { callback(cc, fields) }:
+	case <-cc.Closed():
+	}
+
+	return true
+}
+
+func (cc *ClientConn) formspecLoop() {
+	for {
+		select {
+		case <-cc.Closed():
+			return
+		case f := <-cc.formspecCh:
+			f()
+		}
+	}
+}
@@ -5,4 +5,9 @@ go 1.18
 require (
 	github.com/HimbeerserverDE/srp v0.0.0
 	github.com/anon55555/mt v0.0.0-20210919124550-bcc58cb3048f
+	github.com/mattn/go-sqlite3 v1.14.16
+	golang.org/x/crypto v0.17.0
+	golang.org/x/term v0.15.0
 )
+
+require golang.org/x/sys v0.15.0 // indirect
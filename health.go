@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/anon55555/mt"
+)
+
+// healthCheckName is the player name used for health check handshakes.
+const healthCheckName = "proxyhealthcheck"
+
+// A ServerHealth contains the result of the most recent
+// health check of an upstream server.
+type ServerHealth struct {
+	Up        bool
+	Latency   time.Duration
+	LastCheck time.Time
+	Err       error
+}
+
+var serverHealth = make(map[string]ServerHealth)
+var serverHealthMu sync.RWMutex
+
+// ServerHealthInfo returns the health state of a server
+// and whether it has been checked yet.
+func ServerHealthInfo(name string) (ServerHealth, bool) {
+	serverHealthMu.RLock()
+	defer serverHealthMu.RUnlock()
+
+	h, ok := serverHealth[name]
+	return h, ok
+}
+
+// ServerHealths returns the health state of all servers
+// that have been checked.
+func ServerHealths() map[string]ServerHealth {
+	serverHealthMu.RLock()
+	defer serverHealthMu.RUnlock()
+
+	healths := make(map[string]ServerHealth, len(serverHealth))
+	for name, h := range serverHealth {
+		healths[name] = h
+	}
+
+	return healths
+}
+
+// ServerUp reports whether a server is considered reachable.
+// Servers that haven't been checked yet and all servers
+// while health checks are disabled are considered reachable.
+func ServerUp(name string) bool {
+	return serverUp(Conf(), name)
+}
+
+func serverUp(conf Config, name string) bool {
+	if !conf.HealthCheck.Enable {
+		return true
+	}
+
+	h, ok := ServerHealthInfo(name)
+	return !ok || h.Up
+}
+
+// healthCheckLoop periodically checks all servers
+// while health checks are enabled.
+func healthCheckLoop() {
+	for {
+		conf := Conf()
+		if conf.HealthCheck.Enable {
+			checkServers(conf)
+		} else {
+			serverHealthMu.Lock()
+			serverHealth = make(map[string]ServerHealth)
+			serverHealthMu.Unlock()
+		}
+
+		select {
+		case <-consoleCh:
+			return
+		case <-time.After(time.Duration(conf.HealthCheck.Interval) * time.Second):
+		}
+	}
+}
+
+func checkServers(conf Config) {
+	var wg sync.WaitGroup
+	timeout := time.Duration(conf.HealthCheck.Timeout) * time.Second
+
+	for name, srv := range conf.Servers {
+		wg.Add(1)
+		go func(name, addr string) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := probeServer(addr, timeout)
+
+			h := ServerHealth{
+				Up:        err == nil,
+				LastCheck: time.Now(),
+				Err:       err,
+			}
+
+			if h.Up {
+				h.Latency = time.Since(start)
+			}
+
+			serverHealthMu.Lock()
+			prev, ok := serverHealth[name]
+			serverHealth[name] = h
+			serverHealthMu.Unlock()
+
+			if h.Up && ok && !prev.Up {
+				log.Println("server", name, "up")
+			} else if !h.Up && (!ok || prev.Up) {
+				log.Println("server", name, "down:", err)
+			}
+		}(name, srv.Addr)
+	}
+
+	wg.Wait()
+
+	// Forget servers that have been removed.
+	serverHealthMu.Lock()
+	defer serverHealthMu.Unlock()
+
+	for name := range serverHealth {
+		if _, ok := conf.Servers[name]; !ok {
+			delete(serverHealth, name)
+		}
+	}
+}
+
+// probeServer performs the first step of the handshake
+// with a server. Any response is considered healthy.
+func probeServer(strAddr string, timeout time.Duration) error {
+	addr, err := net.ResolveUDPAddr("udp", strAddr)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return err
+	}
+
+	peer := mt.Connect(conn)
+	defer peer.Close()
+
+	go func() {
+		select {
+		case <-peer.Closed():
+		case <-time.After(timeout):
+			peer.Close()
+		}
+	}()
+
+	if _, err := peer.SendCmd(&mt.ToSrvInit{
+		SerializeVer: serializeVer,
+		MinProtoVer:  protoVer,
+		MaxProtoVer:  protoVer,
+		PlayerName:   healthCheckName,
+	}); err != nil {
+		return err
+	}
+
+	// Other errors are caused by packets that couldn't be decoded
+	// which still means that the server is responding.
+	if _, err := peer.Recv(); errors.Is(err, net.ErrClosed) {
+		if why := peer.WhyClosed(); why != nil {
+			return why
+		}
+
+		return errors.New("no response")
+	}
+
+	return nil
+}
@@ -1,20 +1,55 @@
 package proxy
 
 import (
+	"errors"
 	"fmt"
 	"image/color"
 	"net"
+	"time"
 
 	"github.com/anon55555/mt"
 )
 
+// hopTimeout is the maximum time a hop may take
+// before the connection to the new server is abandoned.
+const hopTimeout = 10 * time.Second
+
 // Hop connects the ClientConn to the specified upstream server.
-// At the moment the ClientConn is NOT fixed if an error occurs
-// so the player may have to reconnect.
+// If a server group is specified one of its members is picked.
+// The new server connection is established in the background.
+// The ClientConn is only switched over to it once it is ready.
+// If an error occurs the ClientConn stays on its current server
+// and is informed using a chat message.
+// If the server is full and queueing is enabled the ClientConn
+// is added to its queue and ErrQueued is returned.
+// The limbo server can be joined using its configured name.
 func (cc *ClientConn) Hop(serverName string) error {
+	return cc.hop(serverName, true)
+}
+
+func (cc *ClientConn) hop(serverName string, queue bool) error {
 	cc.hopMu.Lock()
 	defer cc.hopMu.Unlock()
 
+	return cc.hopLocked(serverName, queue)
+}
+
+// hopLocked is like hop. The caller must hold hopMu.
+func (cc *ClientConn) hopLocked(serverName string, queue bool) (err error) {
+	if queue && cc.waitingForSlot() {
+		return fmt.Errorf("waiting for a free slot")
+	}
+
+	if _, ok := Conf().ServerGroups[serverName]; ok {
+		member, ok := ResolveServer(serverName)
+		if !ok {
+			return fmt.Errorf("no member of server group %s is available", serverName)
+		}
+
+		cc.Log("<->", "pick", member, "from group", serverName)
+		serverName = member
+	}
+
 	cc.Log("<->", "hop", serverName)
 
 	if cc.server() == nil {
@@ -23,11 +58,20 @@ func (cc *ClientConn) Hop(serverName string) error {
 		return err
 	}
 
+	limbo := isLimbo(serverName)
+
 	var strAddr string
-	for name, srv := range Conf().Servers {
-		if name == serverName {
-			strAddr = srv.Addr
-			break
+	if limbo {
+		strAddr, err = startLimbo()
+		if err != nil {
+			return err
+		}
+	} else {
+		for name, srv := range Conf().Servers {
+			if name == serverName {
+				strAddr = srv.Addr
+				break
+			}
 		}
 	}
 
@@ -35,16 +79,60 @@ func (cc *ClientConn) Hop(serverName string) error {
 		return fmt.Errorf("inexistent server")
 	}
 
+	// The new connection is established before the old one is closed,
+	// so the server would reject it as a duplicate login.
+	if cur := cc.ServerName(); cur == serverName || serverAddr(cur) == strAddr {
+		return fmt.Errorf("already connected to %s", serverName)
+	}
+
+	// Only existing servers are counted
+	// so that the number of label values is bounded.
+	metricHops.inc(serverName)
+	defer func() {
+		if err != nil {
+			metricHopFails.inc(serverName)
+		}
+	}()
+
+	if err := cc.CanJoin(serverName); err != nil && !limbo {
+		if queue && errors.Is(err, ErrServerFull) && Conf().Queue.Enable {
+			cc.enqueue(serverName)
+			return ErrQueued
+		}
+
+		cc.Log("<->", "hop deny", serverName, err)
+		cc.SendChatMsg("You can't join", serverName+":", err.Error())
+		return err
+	}
+
+	if err := firePreHop(cc, serverName); err != nil {
+		cc.Log("<->", "hop veto", serverName, err)
+		return err
+	}
+
+	sc, err := cc.dialHop(serverName, strAddr)
+	if err != nil {
+		cc.Log("<->", "hop fail", serverName, err)
+		cc.SendChatMsg("Could not connect to", serverName+":", err.Error())
+		return err
+	}
+
+	old := cc.server()
+	if old == nil {
+		sc.abandon()
+		return fmt.Errorf("server connection lost during hop")
+	}
+
 	// This needs to be done before the ServerConn is closed
 	// so the clientConn isn't closed by the packet handler
-	cc.server().mu.Lock()
-	cc.server().clt = nil
-	cc.server().mu.Unlock()
+	old.mu.Lock()
+	old.clt = nil
+	old.mu.Unlock()
 
-	cc.server().Close()
+	old.Close()
 
 	// Reset the client to its initial state
-	for _, inv := range cc.server().detachedInvs {
+	for _, inv := range old.detachedInvs {
 		cc.SendCmd(&mt.ToCltDetachedInv{
 			Name: inv,
 			Keep: false,
@@ -52,20 +140,20 @@ func (cc *ClientConn) Hop(serverName string) error {
 	}
 
 	var aoRm []mt.AOID
-	for ao := range cc.server().aos {
+	for ao := range old.aos {
 		aoRm = append(aoRm, ao)
 	}
 	cc.SendCmd(&mt.ToCltAORmAdd{Remove: aoRm})
 
-	for spawner := range cc.server().particleSpawners {
+	for spawner := range old.particleSpawners {
 		cc.SendCmd(&mt.ToCltDelParticleSpawner{ID: spawner})
 	}
 
-	for sound := range cc.server().sounds {
+	for sound := range old.sounds {
 		cc.SendCmd(&mt.ToCltStopSound{ID: sound})
 	}
 
-	for hud := range cc.server().huds {
+	for hud := range old.huds {
 		cc.SendCmd(&mt.ToCltRmHUD{ID: hud})
 	}
 
@@ -127,7 +215,7 @@ func (cc *ClientConn) Hop(serverName string) error {
 	})
 
 	var players []string
-	for player := range cc.server().playerList {
+	for player := range old.playerList {
 		players = append(players, player)
 	}
 
@@ -137,28 +225,98 @@ func (cc *ClientConn) Hop(serverName string) error {
 	})
 
 	cc.mu.Lock()
-	cc.srv = nil
+	cc.srv = sc
 	cc.mu.Unlock()
 
+	close(sc.attachCh)
+	serverConnected(Conf(), serverName)
+
+	// Players that move to another server manually
+	// no longer wait for the server they were queued for.
+	if srv, _, ok := cc.QueuePos(); ok && srv != "" && !limbo {
+		cc.dequeue()
+	}
+
+	// A slot on the previous server has become available.
+	signalQueue()
+
+	if !limbo {
+		cc.unpark()
+	}
+
+	for ch := range cc.modChs {
+		cc.server().SendCmd(&mt.ToSrvJoinModChan{Channel: ch})
+	}
+
+	firePostHop(cc, old.name, serverName)
+
+	if !Conf().ForceDefaultSrv && !limbo {
+		return authIface.SetLastSrv(cc.Name(), serverName)
+	}
+
+	return nil
+}
+
+// dialHop connects to an upstream server in the background
+// and waits for the connection to become ready.
+// The returned ServerConn hasn't been attached to the ClientConn yet.
+func (cc *ClientConn) dialHop(serverName, strAddr string) (*ServerConn, error) {
+	if !ServerUp(serverName) {
+		return nil, fmt.Errorf("server is down")
+	}
+
 	addr, err := net.ResolveUDPAddr("udp", strAddr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	conn, err := net.DialUDP("udp", nil, addr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	connect(conn, serverName, cc)
+	sc := newServerConn(conn, serverName, cc)
+	go handleSrv(sc)
 
-	for ch := range cc.modChs {
-		cc.server().SendCmd(&mt.ToSrvJoinModChan{Channel: ch})
+	select {
+	case <-sc.Init():
+		return sc, nil
+	case <-sc.Closed():
+		sc.mu.RLock()
+		err = sc.kickErr
+		sc.mu.RUnlock()
+
+		if err == nil {
+			err = fmt.Errorf("connection closed")
+		}
+	case <-cc.Closed():
+		err = fmt.Errorf("client disconnected")
+	case <-time.After(hopTimeout):
+		err = fmt.Errorf("timeout")
 	}
 
-	if !Conf().ForceDefaultSrv {
-		return authIface.SetLastSrv(cc.Name(), serverName)
+	sc.abandon()
+	return nil, err
+}
+
+// abandon closes a ServerConn that was never attached to its client
+// without affecting the client.
+func (sc *ServerConn) abandon() {
+	sc.mu.Lock()
+	sc.clt = nil
+	sc.mu.Unlock()
+
+	sc.Close()
+	close(sc.attachCh)
+}
+
+// serverAddr returns the address of a server or the limbo server.
+// It returns an empty string if the server doesn't exist.
+func serverAddr(name string) string {
+	if isLimbo(name) {
+		addr, _ := startLimbo()
+		return addr
 	}
 
-	return nil
+	return Conf().Servers[name].Addr
 }
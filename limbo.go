@@ -0,0 +1,328 @@
+package proxy
+
+import (
+	"errors"
+	"image/color"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anon55555/mt"
+)
+
+// limboPos is the node position players are placed at in the limbo
+// server. It is far away from the areas of regular servers
+// so that the map of the previous server isn't visible.
+var limboPos = [3]int16{0, 30000, 0}
+
+var limboAddr string
+var limboErr error
+var limboOnce sync.Once
+
+// parked maps players that have been moved to the limbo server
+// to the server they lost the connection to.
+var parked = make(map[*ClientConn]string)
+var parkedMu sync.Mutex
+var parkCh = make(chan struct{}, 1)
+
+var parkOnce sync.Once
+
+// limboAvailable reports whether the limbo server
+// is enabled and running.
+func limboAvailable() bool {
+	if !Conf().Limbo.Enable {
+		return false
+	}
+
+	_, err := startLimbo()
+	return err == nil
+}
+
+// isLimbo reports whether a server name refers to the limbo server.
+func isLimbo(name string) bool {
+	conf := Conf()
+	return conf.Limbo.Enable && name == conf.Limbo.Name
+}
+
+// startLimbo starts the limbo server on a local port
+// if it isn't running yet and returns its address.
+func startLimbo() (string, error) {
+	limboOnce.Do(func() {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			limboErr = err
+			log.Print(err)
+			return
+		}
+
+		limboAddr = pc.LocalAddr().String()
+		log.Println("listen limbo", limboAddr)
+
+		go serveLimbo(mt.Listen(pc))
+	})
+
+	return limboAddr, limboErr
+}
+
+func serveLimbo(l mt.Listener) {
+	for {
+		peer, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			log.Print(err)
+			continue
+		}
+
+		go handleLimbo(peer)
+	}
+}
+
+// handleLimbo performs the handshake with a ServerConn
+// and places the player in an empty world.
+// Everything sent by the player is ignored.
+func handleLimbo(peer mt.Peer) {
+	defer peer.Close()
+
+	var name string
+	for {
+		pkt, err := peer.Recv()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			continue
+		}
+
+		switch cmd := pkt.Cmd.(type) {
+		case *mt.ToSrvInit:
+			// The handshake is retried until the first reply arrives.
+			if name != "" {
+				break
+			}
+
+			name = cmd.PlayerName
+			peer.SendCmd(&mt.ToCltHello{
+				SerializeVer: serializeVer,
+				ProtoVer:     protoVer,
+				AuthMethods:  mt.FirstSRP,
+				Username:     name,
+			})
+		case *mt.ToSrvFirstSRP:
+			peer.SendCmd(&mt.ToCltAcceptAuth{
+				PlayerPos:       limboPlayerPos(5),
+				SendInterval:    Conf().SendInterval,
+				SudoAuthMethods: mt.SRP,
+			})
+		case *mt.ToSrvInit2:
+			peer.SendCmd(&mt.ToCltItemDefs{})
+			peer.SendCmd(&mt.ToCltNodeDefs{})
+			peer.SendCmd(&mt.ToCltAnnounceMedia{})
+		case *mt.ToSrvCltReady:
+			sendLimboWorld(peer)
+		}
+	}
+}
+
+// limboPlayerPos returns the position of the player
+// in the limbo server in BS units with an additional
+// vertical offset.
+func limboPlayerPos(offset float32) mt.Pos {
+	return mt.Pos{
+		float32(limboPos[0]) * 10,
+		float32(limboPos[1])*10 + offset,
+		float32(limboPos[2]) * 10,
+	}
+}
+
+// sendLimboWorld sends an empty world to the player.
+// The area around the player is filled with air, the player
+// can't move, the sky is plain and the HUD and inventory are empty.
+func sendLimboWorld(peer mt.Peer) {
+	peer.SendCmd(&mt.ToCltMovement{})
+	peer.SendCmd(&mt.ToCltMovePlayer{Pos: limboPlayerPos(0)})
+	peer.SendCmd(&mt.ToCltHP{HP: 20})
+
+	var blk mt.MapBlk
+	blk.LitFrom = mt.AlwaysLitFrom
+	for i := range blk.Param0 {
+		blk.Param0[i] = mt.Air
+	}
+
+	center := [3]int16{limboPos[0] >> 4, limboPos[1] >> 4, limboPos[2] >> 4}
+	for x := int16(-1); x <= 1; x++ {
+		for y := int16(-1); y <= 1; y++ {
+			for z := int16(-1); z <= 1; z++ {
+				peer.SendCmd(&mt.ToCltBlkData{
+					Blkpos: [3]int16{center[0] + x, center[1] + y, center[2] + z},
+					Blk:    blk,
+				})
+			}
+		}
+	}
+
+	peer.SendCmd(&mt.ToCltSkyParams{
+		Type:        "plain",
+		BgColor:     color.NRGBA{16, 16, 24, 255},
+		FogTintType: "default",
+	})
+	peer.SendCmd(&mt.ToCltSunParams{})
+	peer.SendCmd(&mt.ToCltMoonParams{})
+	peer.SendCmd(&mt.ToCltStarParams{})
+	peer.SendCmd(&mt.ToCltCloudParams{})
+	peer.SendCmd(&mt.ToCltHUDFlags{Mask: ^mt.HUDFlags(0)})
+
+	inv := mt.Inv{{
+		Name: "main",
+		InvList: mt.InvList{
+			Stacks: make([]mt.Stack, 32),
+		},
+	}}
+
+	b := &strings.Builder{}
+	inv.Serialize(b)
+	peer.SendCmd(&mt.ToCltInv{Inv: b.String()})
+}
+
+// park moves the ClientConn to the limbo server after its
+// connection to the specified ServerConn has been lost.
+// It is moved back to that server or one of its fallbacks
+// automatically as soon as one of them is reachable.
+// It returns false if the ClientConn couldn't be parked
+// and has to be kicked.
+func (cc *ClientConn) park(sc *ServerConn) bool {
+	if !limboAvailable() || isLimbo(sc.name) {
+		return false
+	}
+
+	select {
+	case <-cc.Closed():
+		return false
+	default:
+	}
+
+	cc.hopMu.Lock()
+	defer cc.hopMu.Unlock()
+
+	// A hop may have completed in the meantime.
+	if cc.server() != sc {
+		return true
+	}
+
+	if err := cc.hopLocked(Conf().Limbo.Name, false); err != nil {
+		cc.Log("<->", "park fail", err)
+		return false
+	}
+
+	cc.Log("<->", "park from", sc.name)
+	cc.SendChatMsg("You have been moved to limbo and will be moved back once", sc.name, "is available again.")
+
+	cc.markParked(sc.name)
+	return true
+}
+
+// markParked records that the ClientConn is in the limbo server
+// and waiting for the specified server. An empty server name
+// means that the ClientConn is waiting for any server.
+func (cc *ClientConn) markParked(server string) {
+	parkOnce.Do(func() {
+		go limboLoop()
+	})
+
+	parkedMu.Lock()
+	parked[cc] = server
+	parkedMu.Unlock()
+
+	select {
+	case parkCh <- struct{}{}:
+	default:
+	}
+}
+
+// unpark stops moving the ClientConn back from the limbo server.
+func (cc *ClientConn) unpark() {
+	parkedMu.Lock()
+	defer parkedMu.Unlock()
+
+	delete(parked, cc)
+}
+
+func limboLoop() {
+	for {
+		select {
+		case <-consoleCh:
+			return
+		case <-parkCh:
+		case <-time.After(time.Duration(Conf().Limbo.RetryInterval) * time.Second):
+		}
+
+		unparkPlayers()
+	}
+}
+
+// unparkPlayers moves parked players back
+// to the first reachable server they can join.
+func unparkPlayers() {
+	parkedMu.Lock()
+	clts := make(map[*ClientConn]string, len(parked))
+	for cc, server := range parked {
+		clts[cc] = server
+	}
+	parkedMu.Unlock()
+
+	if len(clts) == 0 {
+		return
+	}
+
+	conf := Conf()
+	timeout := time.Duration(conf.HealthCheck.Timeout) * time.Second
+
+	// Each server is only probed once per attempt.
+	probes := make(map[string]error)
+	reachable := func(name string) bool {
+		err, ok := probes[name]
+		if !ok {
+			err = probeServer(conf.Servers[name].Addr, timeout)
+			probes[name] = err
+		}
+
+		return err == nil
+	}
+
+	for cc, server := range clts {
+		if cc.server() == nil || cc.server().state() < csActive || !isLimbo(cc.ServerName()) {
+			continue
+		}
+
+		for _, name := range parkTargets(conf, server) {
+			if cc.CanJoin(name) != nil || !reachable(name) {
+				continue
+			}
+
+			if err := cc.hop(name, false); err != nil {
+				cc.Log("<->", "unpark fail", name, err)
+				continue
+			}
+
+			break
+		}
+	}
+}
+
+// parkTargets returns the servers a player that is waiting
+// for the specified server may be moved back to in order.
+func parkTargets(conf Config, server string) []string {
+	if _, ok := conf.Servers[server]; !ok {
+		server = conf.DefaultServerName()
+		if server == "" {
+			return nil
+		}
+	}
+
+	return append([]string{server}, FallbackServers(server)...)
+}
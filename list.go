@@ -9,6 +9,7 @@ import (
 	"net"
 	"net/http"
 	"net/textproto"
+	"sort"
 	"sync"
 	"time"
 )
@@ -58,6 +59,8 @@ func announce(action string) error {
 		}
 		playersMu.RUnlock()
 
+		sort.Strings(clts)
+
 		a["clients_max"] = Conf().UserLimit
 		a["clients_list"] = clts
 		a["gameid"] = Conf().List.Game
@@ -92,26 +95,56 @@ func announce(action string) error {
 	return nil
 }
 
+var listReloadCh = make(chan struct{}, 1)
+
 func init() {
-	if Conf().List.Enable {
-		go func() {
-			var added bool
-			t := time.NewTicker(time.Duration(Conf().List.Interval) * time.Second)
-			for {
-				<-t.C
-				if !added {
-					if err := announce(listAdd); err != nil {
-						log.Print(err)
-					}
-
-					added = true
-					continue
-				}
+	go listLoop()
+}
+
+// listLoop periodically announces the proxy to the server list
+// while it is enabled. It re-reads the list configuration
+// when the config is reloaded.
+func listLoop() {
+	var added bool
+	for {
+		conf := Conf()
+
+		var t *time.Ticker
+		var tick <-chan time.Time
+		if conf.List.Enable {
+			t = time.NewTicker(time.Duration(conf.List.Interval) * time.Second)
+			tick = t.C
+		} else if added {
+			if err := announce(listRm); err != nil {
+				log.Print(err)
+			}
+
+			added = false
+		}
 
+		select {
+		case <-tick:
+			action := listUpdate
+			if !added {
+				action = listAdd
+			}
+
+			if err := announce(action); err != nil {
+				log.Print(err)
+				break
+			}
+
+			added = true
+		case <-listReloadCh:
+			if added && Conf().List.Enable {
 				if err := announce(listUpdate); err != nil {
 					log.Print(err)
 				}
 			}
-		}()
+		}
+
+		if t != nil {
+			t.Stop()
+		}
 	}
 }
@@ -75,6 +75,8 @@ func (l *listener) accept() (*ClientConn, error) {
 		logger: log.New(logWriter, prefix, log.LstdFlags|log.Lmsgprefix),
 		initCh: make(chan struct{}),
 		modChs: make(map[string]struct{}),
+
+		formspecs: make(map[string]FormspecHandler),
 	}
 
 	l.mu.Lock()
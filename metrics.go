@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A counterVec is a set of monotonically increasing counters
+// that are distinguished by a single label value.
+type counterVec struct {
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+func (cv *counterVec) add(label string, n uint64) {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	if cv.values == nil {
+		cv.values = make(map[string]uint64)
+	}
+
+	cv.values[label] += n
+}
+
+func (cv *counterVec) inc(label string) { cv.add(label, 1) }
+
+func (cv *counterVec) snapshot() map[string]uint64 {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	values := make(map[string]uint64, len(cv.values))
+	for label, n := range cv.values {
+		values[label] = n
+	}
+
+	return values
+}
+
+var (
+	metricHops         counterVec
+	metricHopFails     counterVec
+	metricAuth         counterVec
+	metricFallbacks    counterVec
+	metricMediaBytes   uint64
+	metricPktsToSrv    uint64
+	metricPktsToClt    uint64
+	metricMuxCount     uint64
+	metricMuxNanos     uint64
+	metricMuxFailCount uint64
+)
+
+func observeContentMux(d time.Duration, err error) {
+	atomic.AddUint64(&metricMuxCount, 1)
+	atomic.AddUint64(&metricMuxNanos, uint64(d))
+
+	if err != nil {
+		atomic.AddUint64(&metricMuxFailCount, 1)
+	}
+}
+
+func serveMetrics() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+
+	ln, err := net.Listen("tcp", Conf().Metrics.Addr)
+	if err != nil {
+		return err
+	}
+
+	log.Println("listen metrics", ln.Addr())
+
+	srv := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		ErrorLog:     log.New(logWriter, "[metrics] ", log.LstdFlags|log.Lmsgprefix),
+	}
+
+	go func() {
+		<-consoleCh
+		srv.Close()
+	}()
+
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// handleMetrics writes all metrics
+// in the Prometheus text exposition format.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeMetric(w, "uptime_seconds", "gauge", "Time the proxy has been running for.")
+	fmt.Fprintf(w, "mt_multiserver_proxy_uptime_seconds %g\n", Uptime().Seconds())
+
+	players := make(map[string]uint64)
+	for name := range Conf().Servers {
+		players[name] = 0
+	}
+
+	for cc := range Clts() {
+		if srv := cc.ServerName(); srv != "" {
+			players[srv]++
+		}
+	}
+
+	writeMetric(w, "players", "gauge", "Number of players connected to an upstream server.")
+	writeMetricVec(w, "players", "server", players)
+
+	if Conf().HealthCheck.Enable {
+		up := make(map[string]uint64)
+		latency := make(map[string]float64)
+		for name, h := range ServerHealths() {
+			up[name] = 0
+			if h.Up {
+				up[name] = 1
+				latency[name] = h.Latency.Seconds()
+			}
+		}
+
+		writeMetric(w, "server_up", "gauge", "Whether an upstream server responded to the last health check.")
+		writeMetricVec(w, "server_up", "server", up)
+
+		writeMetric(w, "server_latency_seconds", "gauge", "Handshake latency of an upstream server during the last health check.")
+		writeMetricVecFloat(w, "server_latency_seconds", "server", latency)
+	}
+
+	writeMetric(w, "hops_total", "counter", "Number of hops to an upstream server.")
+	writeMetricVec(w, "hops_total", "server", metricHops.snapshot())
+
+	writeMetric(w, "hop_failures_total", "counter", "Number of failed hops to an upstream server.")
+	writeMetricVec(w, "hop_failures_total", "server", metricHopFails.snapshot())
+
+	writeMetric(w, "fallbacks_total", "counter", "Number of times players were moved to fallback servers, by the server that kicked them.")
+	writeMetricVec(w, "fallbacks_total", "server", metricFallbacks.snapshot())
+
+	writeMetric(w, "auth_total", "counter", "Number of client authentication attempts by result.")
+	writeMetricVec(w, "auth_total", "result", metricAuth.snapshot())
+
+	writeMetric(w, "packets_forwarded_total", "counter", "Number of packets forwarded by direction.")
+	writeMetricVec(w, "packets_forwarded_total", "direction", map[string]uint64{
+		"serverbound": atomic.LoadUint64(&metricPktsToSrv),
+		"clientbound": atomic.LoadUint64(&metricPktsToClt),
+	})
+
+	writeMetric(w, "media_sent_bytes_total", "counter", "Number of media file bytes sent to clients.")
+	fmt.Fprintf(w, "mt_multiserver_proxy_media_sent_bytes_total %d\n", atomic.LoadUint64(&metricMediaBytes))
+
+	writeMetric(w, "content_mux_duration_seconds", "summary", "Time taken to multiplex the content of all servers.")
+	fmt.Fprintf(w, "mt_multiserver_proxy_content_mux_duration_seconds_sum %g\n", time.Duration(atomic.LoadUint64(&metricMuxNanos)).Seconds())
+	fmt.Fprintf(w, "mt_multiserver_proxy_content_mux_duration_seconds_count %d\n", atomic.LoadUint64(&metricMuxCount))
+
+	writeMetric(w, "content_mux_failures_total", "counter", "Number of failed content multiplexing attempts.")
+	fmt.Fprintf(w, "mt_multiserver_proxy_content_mux_failures_total %d\n", atomic.LoadUint64(&metricMuxFailCount))
+}
+
+func writeMetric(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP mt_multiserver_proxy_%s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE mt_multiserver_proxy_%s %s\n", name, typ)
+}
+
+func writeMetricVec(w io.Writer, name, label string, values map[string]uint64) {
+	floats := make(map[string]float64, len(values))
+	for l, v := range values {
+		floats[l] = float64(v)
+	}
+
+	writeMetricVecFloat(w, name, label, floats)
+}
+
+func writeMetricVecFloat(w io.Writer, name, label string, values map[string]float64) {
+	labels := make([]string, 0, len(values))
+	for l := range values {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+
+	escaper := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+	for _, l := range labels {
+		fmt.Fprintf(w, "mt_multiserver_proxy_%s{%s=\"%s\"} %g\n", name, label, escaper.Replace(l), values[l])
+	}
+}
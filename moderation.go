@@ -1,7 +1,9 @@
 package proxy
 
 import (
+	"fmt"
 	"net"
+	"time"
 
 	"github.com/anon55555/mt"
 )
@@ -23,20 +25,131 @@ func (cc *ClientConn) Kick(reason string) {
 	}()
 }
 
-// Ban disconnects the ClientConn and prevents the underlying
-// network address from connecting again.
-func (cc *ClientConn) Ban() error {
-	cc.Kick("Banned by proxy.")
-	return authIface.Ban(cc.RemoteAddr().(*net.UDPAddr).IP.String(), cc.name)
+// Ban disconnects the ClientConn and prevents both the underlying
+// network address and the player name from connecting again.
+// The reason is shown to the player and the issuer is the name
+// of the moderator responsible for the ban.
+// A duration of 0 bans permanently.
+func (cc *ClientConn) Ban(reason, issuer string, d time.Duration) error {
+	b := Ban{
+		Addr:   cc.RemoteAddr().(*net.UDPAddr).IP.String(),
+		Name:   cc.name,
+		Reason: reason,
+		Issuer: issuer,
+	}
+
+	if d > 0 {
+		b.Expiry = time.Now().Add(d)
+	}
+
+	return AddBan(b)
 }
 
-// Unban removes a player from the ban list. It accepts both
-// network addresses and player names.
+// AddBan adds a ban entry and kicks all connected players
+// matching it. The address of the Ban can be a single network
+// address, a CIDR range or empty if only the name should be banned.
+// The creation time is set automatically if it is zero.
+func AddBan(b Ban) error {
+	if b.Addr == "" && b.Name == "" {
+		return fmt.Errorf("ban has neither address nor name")
+	}
+
+	if b.Addr != "" {
+		addr, err := normalizeBanAddr(b.Addr)
+		if err != nil {
+			return err
+		}
+
+		b.Addr = addr
+	}
+
+	if b.Created.IsZero() {
+		b.Created = time.Now()
+	}
+
+	if err := authIface.Ban(b); err != nil {
+		return err
+	}
+
+	for cc := range Clts() {
+		if b.Matches(cc.RemoteAddr().(*net.UDPAddr), cc.Name()) {
+			cc.Log("<-", "ban")
+			cc.Kick(b.KickMsg())
+		}
+	}
+
+	return nil
+}
+
+// Unban removes all matching entries from the ban list.
+// It accepts network addresses, CIDR ranges and player names.
 func Unban(id string) error {
+	if addr, err := normalizeBanAddr(id); err == nil {
+		id = addr
+	}
+
 	return authIface.Unban(id)
 }
 
-// Banned reports whether a network address is banned.
-func Banned(addr *net.UDPAddr) bool {
-	return authIface.Banned(addr)
+// Banned reports whether a network address or player name is banned
+// and returns the ban entry if it is.
+func Banned(addr *net.UDPAddr, name string) (Ban, bool) {
+	b, ok := authIface.Banned(addr, name)
+	if !ok || b.Expired() {
+		return Ban{}, false
+	}
+
+	return b, true
+}
+
+// Matches reports whether the Ban applies to a network address
+// or player name.
+func (b Ban) Matches(addr *net.UDPAddr, name string) bool {
+	if b.Name != "" && b.Name == name {
+		return true
+	}
+
+	if b.Addr == "" || addr == nil {
+		return false
+	}
+
+	if _, ipnet, err := net.ParseCIDR(b.Addr); err == nil {
+		return ipnet.Contains(addr.IP)
+	}
+
+	ip := net.ParseIP(b.Addr)
+	return ip != nil && ip.Equal(addr.IP)
+}
+
+// Expired reports whether the Ban has an expiry that has passed.
+func (b Ban) Expired() bool {
+	return !b.Expiry.IsZero() && time.Now().After(b.Expiry)
+}
+
+// KickMsg returns the message that is shown to banned players.
+func (b Ban) KickMsg() string {
+	msg := "Banned by proxy."
+	if b.Reason != "" {
+		msg += " Reason: " + b.Reason
+	}
+
+	if !b.Expiry.IsZero() {
+		msg += " Expires: " + b.Expiry.Format("2006-01-02 15:04:05 MST")
+	}
+
+	return msg
+}
+
+// normalizeBanAddr returns the canonical form
+// of a network address or CIDR range.
+func normalizeBanAddr(addr string) (string, error) {
+	if _, ipnet, err := net.ParseCIDR(addr); err == nil {
+		return ipnet.String(), nil
+	}
+
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip.String(), nil
+	}
+
+	return "", fmt.Errorf("invalid address or range %s", addr)
 }
@@ -1,39 +1,130 @@
 package proxy
 
-// Perms returns the permissions of the ClientConn.
+import "strings"
+
+// Perms returns the permission rules of the ClientConn
+// in order of decreasing precedence. Rules may contain wildcards
+// and rules prefixed with a "-" deny a permission.
+// Use HasPerms to check for permissions.
 func (cc *ClientConn) Perms() []string {
 	if cc.Name() == "" {
 		return []string{}
 	}
 
-	grp, ok := Conf().UserGroups[cc.Name()]
+	return userPerms(Conf(), cc.Name())
+}
+
+// HasPerms returns true if the ClientConn has all
+// of the specified permissions. Otherwise it returns false.
+func (cc *ClientConn) HasPerms(want ...string) bool {
+	return hasPerms(cc.Perms(), want...)
+}
+
+// userPerms returns the permission rules of a user
+// in order of decreasing precedence: Permissions changed at runtime
+// come first, followed by the per-user permissions from the config,
+// the permissions of the group of the user and then those
+// of the groups it inherits from.
+// Within each of these levels denials take precedence.
+func userPerms(conf Config, name string) []string {
+	grp, ok, stored := storedUserPerms(name)
 	if !ok {
-		grp = "default"
+		grp = confUserGroup(conf, name)
 	}
 
-	if perms, ok := Conf().Groups[grp]; ok {
-		return perms
+	return resolvePerms(conf, grp, stored, conf.UserPerms[name])
+}
+
+func confUserGroup(conf Config, name string) string {
+	if grp, ok := conf.UserGroups[name]; ok {
+		return grp
 	}
 
-	return []string{}
+	return "default"
 }
 
-// HasPerms returns true if the ClientConn has all
-// of the specified permissions. Otherwise it returns false.
-func (cc *ClientConn) HasPerms(want ...string) bool {
-	has := map[string]struct{}{
-		"": struct{}{},
+// resolvePerms returns the per-user rule levels followed by
+// the permission rules of the group in order of decreasing precedence.
+func resolvePerms(conf Config, grp string, levels ...[]string) []string {
+	perms := make([]string, 0)
+	for _, level := range levels {
+		perms = append(perms, denialsFirst(level)...)
 	}
 
-	for _, perm := range cc.Perms() {
-		has[perm] = struct{}{}
+	return append(perms, groupPerms(conf, grp, make(map[string]struct{}))...)
+}
+
+// groupPerms returns the permission rules of a group and its
+// parents in order of decreasing precedence. Cyclic inheritance
+// is ignored.
+func groupPerms(conf Config, grp string, visited map[string]struct{}) []string {
+	if _, ok := visited[grp]; ok {
+		return nil
 	}
+	visited[grp] = struct{}{}
+
+	perms := denialsFirst(conf.Groups[grp])
+	for _, parent := range conf.GroupParents[grp] {
+		perms = append(perms, groupPerms(conf, parent, visited)...)
+	}
+
+	return perms
+}
 
+func denialsFirst(rules []string) []string {
+	sorted := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		if strings.HasPrefix(rule, "-") {
+			sorted = append(sorted, rule)
+		}
+	}
+
+	for _, rule := range rules {
+		if !strings.HasPrefix(rule, "-") {
+			sorted = append(sorted, rule)
+		}
+	}
+
+	return sorted
+}
+
+// hasPerms reports whether the permission rules grant
+// all of the wanted permissions. The first matching rule
+// decides. Permissions that don't match any rule are denied.
+func hasPerms(rules []string, want ...string) bool {
 	for _, perm := range want {
-		if _, ok := has[perm]; !ok {
+		if perm == "" {
+			continue
+		}
+
+		granted := false
+		for _, rule := range rules {
+			deny := strings.HasPrefix(rule, "-")
+			if permMatches(strings.TrimPrefix(rule, "-"), perm) {
+				granted = !deny
+				break
+			}
+		}
+
+		if !granted {
 			return false
 		}
 	}
 
 	return true
 }
+
+// permMatches reports whether a permission matches a pattern.
+// "*" matches any permission and "prefix.*" matches
+// any permission starting with "prefix.".
+func permMatches(pattern, perm string) bool {
+	if pattern == "*" {
+		return true
+	}
+
+	if strings.HasSuffix(pattern, ".*") {
+		return strings.HasPrefix(perm, strings.TrimSuffix(pattern, "*"))
+	}
+
+	return pattern == perm
+}
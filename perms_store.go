@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// The runtime permission store holds group assignments and
+// permissions that were changed using the API. It is persisted
+// to perms.json and takes precedence over the configuration file.
+type permStore struct {
+	UserGroups map[string]string
+	UserPerms  map[string][]string
+}
+
+var storedPerms permStore
+var storedPermsMu sync.RWMutex
+var storedPermsOnce sync.Once
+
+func loadPerms() {
+	storedPermsOnce.Do(func() {
+		storedPermsMu.Lock()
+		defer storedPermsMu.Unlock()
+
+		storedPerms.UserGroups = make(map[string]string)
+		storedPerms.UserPerms = make(map[string][]string)
+
+		data, err := os.ReadFile(Path("perms.json"))
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				log.Print(err)
+			}
+
+			return
+		}
+
+		if err := json.Unmarshal(data, &storedPerms); err != nil {
+			log.Print(err)
+		}
+
+		if storedPerms.UserGroups == nil {
+			storedPerms.UserGroups = make(map[string]string)
+		}
+
+		if storedPerms.UserPerms == nil {
+			storedPerms.UserPerms = make(map[string][]string)
+		}
+	})
+}
+
+// savePerms writes the runtime permission store to disk.
+// The caller must hold storedPermsMu.
+func savePerms() error {
+	data, err := json.MarshalIndent(storedPerms, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp := Path("perms.json.tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, Path("perms.json"))
+}
+
+// ListGroups returns all permission groups
+// and the permissions they have.
+func ListGroups() map[string][]string {
+	groups := make(map[string][]string)
+	for name, grpPerms := range Conf().Groups {
+		groups[name] = append([]string{}, grpPerms...)
+	}
+
+	return groups
+}
+
+// UserGroup returns the permission group of a user.
+func UserGroup(name string) string {
+	grp, ok, _ := storedUserPerms(name)
+	if ok {
+		return grp
+	}
+
+	return confUserGroup(Conf(), name)
+}
+
+// SetUserGroup changes the permission group of a user.
+// The change is persisted and overrides the configuration file.
+// The group must exist.
+func SetUserGroup(name, group string) error {
+	if _, ok := Conf().Groups[group]; !ok && group != "default" {
+		return fmt.Errorf("inexistent group %s", group)
+	}
+
+	loadPerms()
+
+	storedPermsMu.Lock()
+	defer storedPermsMu.Unlock()
+
+	storedPerms.UserGroups[name] = group
+	return savePerms()
+}
+
+// GrantPerm grants a permission to a user
+// in addition to those of their group.
+// The change is persisted.
+func GrantPerm(name, perm string) error {
+	loadPerms()
+
+	storedPermsMu.Lock()
+	defer storedPermsMu.Unlock()
+
+	rules := removePerm(storedPerms.UserPerms[name], "-"+perm)
+	rules = removePerm(rules, perm)
+	storedPerms.UserPerms[name] = append(rules, perm)
+
+	return savePerms()
+}
+
+// RevokePerm removes a permission that was granted using GrantPerm.
+// If the user still has the permission because of their group
+// it is explicitly denied. The change is persisted.
+func RevokePerm(name, perm string) error {
+	conf := Conf()
+	loadPerms()
+
+	storedPermsMu.Lock()
+	defer storedPermsMu.Unlock()
+
+	grp, ok := storedPerms.UserGroups[name]
+	if !ok {
+		grp = confUserGroup(conf, name)
+	}
+
+	rules := removePerm(storedPerms.UserPerms[name], perm)
+	rules = removePerm(rules, "-"+perm)
+
+	if hasPerms(resolvePerms(conf, grp, rules, conf.UserPerms[name]), perm) {
+		rules = append(rules, "-"+perm)
+	}
+
+	if len(rules) == 0 {
+		delete(storedPerms.UserPerms, name)
+	} else {
+		storedPerms.UserPerms[name] = rules
+	}
+
+	return savePerms()
+}
+
+func removePerm(rules []string, perm string) []string {
+	out := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		if rule != perm {
+			out = append(out, rule)
+		}
+	}
+
+	return out
+}
+
+// storedUserPerms returns the group assignment and permissions
+// of a user from the runtime permission store.
+func storedUserPerms(name string) (grp string, grpOk bool, rules []string) {
+	loadPerms()
+
+	storedPermsMu.RLock()
+	defer storedPermsMu.RUnlock()
+
+	grp, grpOk = storedPerms.UserGroups[name]
+	return grp, grpOk, append([]string{}, storedPerms.UserPerms[name]...)
+}
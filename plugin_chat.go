@@ -0,0 +1,39 @@
+package proxy
+
+import "sync"
+
+// A ChatMsgHandler is called for every chat message sent by a player
+// that isn't a chat command. It returns the message that is passed
+// on to the next handler, allowing messages to be filtered
+// or rewritten. Returning false cancels the message.
+type ChatMsgHandler func(cc *ClientConn, msg string) (string, bool)
+
+var chatMsgHandlers []ChatMsgHandler
+var chatMsgHandlersMu sync.RWMutex
+
+// RegisterChatMsgHandler adds a new ChatMsgHandler.
+// Handlers are called in the order they were registered in.
+func RegisterChatMsgHandler(handler ChatMsgHandler) {
+	chatMsgHandlersMu.Lock()
+	defer chatMsgHandlersMu.Unlock()
+
+	chatMsgHandlers = append(chatMsgHandlers, handler)
+}
+
+// handleChatMsg runs a chat message through the ChatMsgHandlers
+// and returns the resulting message and whether it should be sent.
+func handleChatMsg(cc *ClientConn, msg string) (string, bool) {
+	chatMsgHandlersMu.RLock()
+	handlers := chatMsgHandlers
+	chatMsgHandlersMu.RUnlock()
+
+	for _, handler := range handlers {
+		var ok bool
+		if msg, ok = handler(cc, msg); !ok {
+			cc.Log("->", "cancel chat message")
+			return "", false
+		}
+	}
+
+	return msg, true
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"sync"
+
+	"github.com/anon55555/mt"
+)
+
+// Event handlers are called synchronously from the goroutine
+// that processes the corresponding packets and must not block.
+
+var onJoin []func(*ClientConn)
+var onLeave []func(*ClientConn)
+var onPreHop []func(*ClientConn, string) error
+var onPostHop []func(cc *ClientConn, from, to string)
+var onAuthFail []func(*ClientConn)
+var onServerKick []func(*ClientConn, *mt.ToCltKick)
+var eventHandlersMu sync.RWMutex
+
+// RegisterOnJoin registers a handler that is called
+// when a player has completed the handshake with the proxy.
+func RegisterOnJoin(handler func(*ClientConn)) {
+	eventHandlersMu.Lock()
+	defer eventHandlersMu.Unlock()
+
+	onJoin = append(onJoin, handler)
+}
+
+// RegisterOnLeave registers a handler that is called
+// when a player that has joined disconnects.
+func RegisterOnLeave(handler func(*ClientConn)) {
+	eventHandlersMu.Lock()
+	defer eventHandlersMu.Unlock()
+
+	onLeave = append(onLeave, handler)
+}
+
+// RegisterOnPreHop registers a handler that is called before
+// a player is moved to the specified server. Server groups have
+// already been resolved. If a handler returns an error
+// the hop is cancelled and the error is returned by ClientConn.Hop.
+func RegisterOnPreHop(handler func(*ClientConn, string) error) {
+	eventHandlersMu.Lock()
+	defer eventHandlersMu.Unlock()
+
+	onPreHop = append(onPreHop, handler)
+}
+
+// RegisterOnPostHop registers a handler that is called
+// after a player has been moved to another server.
+func RegisterOnPostHop(handler func(cc *ClientConn, from, to string)) {
+	eventHandlersMu.Lock()
+	defer eventHandlersMu.Unlock()
+
+	onPostHop = append(onPostHop, handler)
+}
+
+// RegisterOnAuthFail registers a handler that is called
+// when a player fails to authenticate with the proxy.
+// The player is kicked afterwards.
+func RegisterOnAuthFail(handler func(*ClientConn)) {
+	eventHandlersMu.Lock()
+	defer eventHandlersMu.Unlock()
+
+	onAuthFail = append(onAuthFail, handler)
+}
+
+// RegisterOnServerKick registers a handler that is called
+// when a player is kicked by its upstream server. The player
+// may be moved to a fallback server afterwards.
+func RegisterOnServerKick(handler func(*ClientConn, *mt.ToCltKick)) {
+	eventHandlersMu.Lock()
+	defer eventHandlersMu.Unlock()
+
+	onServerKick = append(onServerKick, handler)
+}
+
+func fireJoin(cc *ClientConn) {
+	eventHandlersMu.RLock()
+	handlers := onJoin
+	eventHandlersMu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(cc)
+	}
+}
+
+func fireLeave(cc *ClientConn) {
+	eventHandlersMu.RLock()
+	handlers := onLeave
+	eventHandlersMu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(cc)
+	}
+}
+
+func firePreHop(cc *ClientConn, server string) error {
+	eventHandlersMu.RLock()
+	handlers := onPreHop
+	eventHandlersMu.RUnlock()
+
+	for _, handler := range handlers {
+		if err := handler(cc, server); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func firePostHop(cc *ClientConn, from, to string) {
+	eventHandlersMu.RLock()
+	handlers := onPostHop
+	eventHandlersMu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(cc, from, to)
+	}
+}
+
+func fireAuthFail(cc *ClientConn) {
+	eventHandlersMu.RLock()
+	handlers := onAuthFail
+	eventHandlersMu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(cc)
+	}
+}
+
+func fireServerKick(cc *ClientConn, cmd *mt.ToCltKick) {
+	eventHandlersMu.RLock()
+	handlers := onServerKick
+	eventHandlersMu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(cc, cmd)
+	}
+}
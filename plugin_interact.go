@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"strings"
 	"sync"
 
 	"github.com/anon55555/mt"
@@ -9,7 +10,34 @@ import (
 // A InteractionHandler holds information on how to handle a Minetest Interaction.
 type InteractionHandler struct {
 	Type    Interaction
-	Handler func(*ClientConn, *mt.ToSrvInteract) bool
+	Handler func(*ClientConn, *InteractionInfo) bool
+
+	// NodeNames enables resolving the names of pointed nodes.
+	// The proxy then keeps track of the nodes known to each client,
+	// which increases its memory usage.
+	NodeNames bool
+}
+
+// An InteractionInfo contains a ToSrvInteract
+// and information about the pointed thing and the wielded item
+// as seen by the upstream server.
+type InteractionInfo struct {
+	// Cmd is the packet that is sent to the server.
+	// Active object IDs have already been translated
+	// to the ones used by the server.
+	Cmd *mt.ToSrvInteract
+
+	// Server is the name of the upstream server.
+	Server string
+
+	// Node is the name of the pointed node as defined by the server.
+	// It is empty if no node is pointed at, the node isn't known
+	// or no InteractionHandler has enabled NodeNames.
+	Node string
+
+	// Item is the name of the wielded item as defined by the server.
+	// It is empty if the hand is used or the item isn't known.
+	Item string
 }
 
 type Interaction uint8
@@ -28,21 +56,46 @@ var interactionHandlers []InteractionHandler
 var interactionHandlerMu sync.RWMutex
 var interactionHandlerOnce sync.Once
 
+// trackNodes is set once an InteractionHandler
+// that needs node names has been registered.
+var trackNodes bool
+
 // RegisterInteractionHandler adds a new InteractionHandler.
+// Once an InteractionHandler with NodeNames enabled has been
+// registered the proxy starts keeping track of the nodes around
+// each player so that the names of pointed nodes can be resolved.
 func RegisterInteractionHandler(handler InteractionHandler) {
 	interactionHandlerMu.Lock()
 	defer interactionHandlerMu.Unlock()
 
 	interactionHandlers = append(interactionHandlers, handler)
+	if handler.NodeNames {
+		trackNodes = true
+	}
+}
+
+func nodesTracked() bool {
+	interactionHandlerMu.RLock()
+	defer interactionHandlerMu.RUnlock()
+
+	return trackNodes
 }
 
 func handleInteraction(cmd *mt.ToSrvInteract, cc *ClientConn) bool {
+	interactionHandlerMu.RLock()
+	handlers := interactionHandlers
+	interactionHandlerMu.RUnlock()
+
+	var info *InteractionInfo
 	handled := false
 
-	for _, handler := range interactionHandlers {
-		interaction := Interaction(handler.Type)
-		if interaction == AnyInteraction || interaction == handler.Type {
-			if handler.Handler(cc, cmd) {
+	for _, handler := range handlers {
+		if handler.Type == AnyInteraction || handler.Type == Interaction(cmd.Action) {
+			if info == nil {
+				info = cc.interactionInfo(cmd)
+			}
+
+			if handler.Handler(cc, info) {
 				handled = true
 			}
 		}
@@ -50,3 +103,160 @@ func handleInteraction(cmd *mt.ToSrvInteract, cc *ClientConn) bool {
 
 	return handled
 }
+
+func (cc *ClientConn) interactionInfo(cmd *mt.ToSrvInteract) *InteractionInfo {
+	info := &InteractionInfo{Cmd: cmd}
+
+	sc := cc.server()
+	if sc == nil {
+		return info
+	}
+
+	info.Server = sc.name
+
+	if pointed, ok := cmd.Pointed.(*mt.PointedNode); ok {
+		if p0, ok := sc.nodeAt(pointed.Under); ok && cc.p0SrvMap != nil {
+			info.Node = cc.p0SrvMap[p0].nodeName
+		}
+	}
+
+	info.Item = strings.TrimPrefix(sc.mainItem(int(cmd.ItemSlot)), sc.mediaPool+"_")
+	return info
+}
+
+// updateMainItems copies the item names of the main inventory list.
+// It must be called by the goroutine that processes the packets
+// of the ServerConn whenever the inventory changes.
+func (sc *ServerConn) updateMainItems() {
+	var items []string
+	if main := sc.inv.List("main"); main != nil {
+		items = make([]string, len(main.Stacks))
+		for i, stack := range main.Stacks {
+			items[i] = stack.Name
+		}
+	}
+
+	sc.mainItemsMu.Lock()
+	defer sc.mainItemsMu.Unlock()
+
+	sc.mainItems = items
+}
+
+// mainItem returns the name of the item in a slot
+// of the main inventory list or an empty string.
+func (sc *ServerConn) mainItem(slot int) string {
+	sc.mainItemsMu.RLock()
+	defer sc.mainItemsMu.RUnlock()
+
+	if slot < 0 || slot >= len(sc.mainItems) {
+		return ""
+	}
+
+	return sc.mainItems[slot]
+}
+
+// A trackedBlk holds the content IDs of a map block.
+// Map blocks consisting of a single kind of node, e.g. air,
+// are common and only store that content ID.
+type trackedBlk struct {
+	uniform mt.Content
+	nodes   *[4096]mt.Content
+}
+
+func (blk *trackedBlk) node(i int) mt.Content {
+	if blk.nodes == nil {
+		return blk.uniform
+	}
+
+	return blk.nodes[i]
+}
+
+func (blk *trackedBlk) setNode(i int, param0 mt.Content) {
+	if blk.nodes == nil {
+		if param0 == blk.uniform {
+			return
+		}
+
+		blk.nodes = new([4096]mt.Content)
+		for j := range blk.nodes {
+			blk.nodes[j] = blk.uniform
+		}
+	}
+
+	blk.nodes[i] = param0
+}
+
+// trackBlk stores the content IDs of a map block
+// if they are needed by InteractionHandlers.
+func (sc *ServerConn) trackBlk(pos [3]int16, param0 *[4096]mt.Content) {
+	if !nodesTracked() {
+		return
+	}
+
+	blk := &trackedBlk{uniform: param0[0]}
+	for _, p0 := range param0 {
+		if p0 != blk.uniform {
+			nodes := *param0
+			blk.nodes = &nodes
+			break
+		}
+	}
+
+	sc.blksMu.Lock()
+	defer sc.blksMu.Unlock()
+
+	if sc.blks == nil {
+		sc.blks = make(map[[3]int16]*trackedBlk)
+	}
+
+	sc.blks[pos] = blk
+}
+
+// trackNode updates the content ID of a single node
+// if its map block is being tracked.
+func (sc *ServerConn) trackNode(pos [3]int16, param0 mt.Content) {
+	blkPos, i := nodeIndex(pos)
+
+	sc.blksMu.Lock()
+	defer sc.blksMu.Unlock()
+
+	if blk, ok := sc.blks[blkPos]; ok {
+		blk.setNode(i, param0)
+	}
+}
+
+// untrackBlks forgets map blocks that the client has unloaded.
+func (sc *ServerConn) untrackBlks(blks [][3]int16) {
+	sc.blksMu.Lock()
+	defer sc.blksMu.Unlock()
+
+	for _, pos := range blks {
+		delete(sc.blks, pos)
+	}
+}
+
+// nodeAt returns the content ID of the node at a position
+// as seen by the client. It returns false if the map block
+// isn't being tracked.
+func (sc *ServerConn) nodeAt(pos [3]int16) (mt.Content, bool) {
+	blkPos, i := nodeIndex(pos)
+
+	sc.blksMu.RLock()
+	defer sc.blksMu.RUnlock()
+
+	blk, ok := sc.blks[blkPos]
+	if !ok {
+		return 0, false
+	}
+
+	return blk.node(i), true
+}
+
+// nodeIndex returns the position of the map block containing
+// a node and the index of the node within the map block.
+func nodeIndex(pos [3]int16) ([3]int16, int) {
+	blkPos := [3]int16{pos[0] >> 4, pos[1] >> 4, pos[2] >> 4}
+	i := int(pos[2]&15)*256 + int(pos[1]&15)*16 + int(pos[0]&15)
+
+	return blkPos, i
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/anon55555/mt"
+)
+
+// A PacketHandler holds information on how to handle packets
+// of a specific command type.
+type PacketHandler struct {
+	// Cmd is a value of the command type to handle,
+	// e.g. &mt.ToSrvChatMsg{} or &mt.ToCltHP{}.
+	// Its type also determines the direction.
+	Cmd mt.Cmd
+
+	// Handlers with a lower Priority are called first.
+	// Handlers with the same Priority are called
+	// in the order they were registered in.
+	Priority int
+
+	// Handler is called before the proxy processes a packet.
+	// The ServerConn is the server that sent the packet or that the
+	// packet is going to be sent to. It may be nil if the client
+	// isn't connected to any server. The packet can be modified
+	// in place or replaced with a different command of the same
+	// direction. Returning true drops the packet. Additional
+	// packets can be injected using the SendCmd methods of
+	// the ClientConn and ServerConn.
+	Handler func(*ClientConn, *ServerConn, *mt.Pkt) bool
+}
+
+var packetHandlers []PacketHandler
+var packetHandlersMu sync.RWMutex
+
+// RegisterPacketHandler adds a new PacketHandler. It returns true
+// on success and false if the command type or the handler is missing.
+func RegisterPacketHandler(handler PacketHandler) bool {
+	if handler.Cmd == nil || handler.Handler == nil {
+		return false
+	}
+
+	packetHandlersMu.Lock()
+	defer packetHandlersMu.Unlock()
+
+	// Packets may be processed concurrently,
+	// so the slice must not be modified in place.
+	handlers := make([]PacketHandler, 0, len(packetHandlers)+1)
+	handlers = append(handlers, packetHandlers...)
+	handlers = append(handlers, handler)
+
+	sort.SliceStable(handlers, func(i, j int) bool {
+		return handlers[i].Priority < handlers[j].Priority
+	})
+
+	packetHandlers = handlers
+	return true
+}
+
+// handlePacket calls the PacketHandlers that match the packet
+// and reports whether it has been dropped.
+func handlePacket(cc *ClientConn, sc *ServerConn, pkt *mt.Pkt) bool {
+	packetHandlersMu.RLock()
+	handlers := packetHandlers
+	packetHandlersMu.RUnlock()
+
+	if len(handlers) == 0 {
+		return false
+	}
+
+	_, toSrv := pkt.Cmd.(mt.ToSrvCmd)
+
+	for _, handler := range handlers {
+		if reflect.TypeOf(handler.Cmd) != reflect.TypeOf(pkt.Cmd) {
+			continue
+		}
+
+		if handler.Handler(cc, sc, pkt) {
+			return true
+		}
+
+		// Commands must not be replaced with ones
+		// of the opposite direction.
+		if _, ok := pkt.Cmd.(mt.ToSrvCmd); pkt.Cmd == nil || ok != toSrv {
+			cc.Log("<->", "packet handler returned invalid command", pkt.Cmd)
+			return true
+		}
+	}
+
+	return false
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/HimbeerserverDE/srp"
@@ -21,6 +22,11 @@ func (cc *ClientConn) process(pkt mt.Pkt) {
 		}
 
 		srv.Send(pkt)
+		atomic.AddUint64(&metricPktsToSrv, 1)
+	}
+
+	if handlePacket(cc, srv, &pkt) {
+		return
 	}
 
 	switch cmd := pkt.Cmd.(type) {
@@ -88,9 +94,9 @@ func (cc *ClientConn) process(pkt mt.Pkt) {
 		cc.name = cmd.PlayerName
 		cc.logger.SetPrefix(fmt.Sprintf("[%s %s] ", cc.RemoteAddr(), cc.Name()))
 
-		if authIface.Banned(cc.RemoteAddr().(*net.UDPAddr)) {
+		if b, ok := Banned(cc.RemoteAddr().(*net.UDPAddr), cc.Name()); ok {
 			cc.Log("<-", "banned")
-			cc.Kick("Banned by proxy.")
+			cc.Kick(b.KickMsg())
 			return
 		}
 
@@ -127,22 +133,30 @@ func (cc *ClientConn) process(pkt mt.Pkt) {
 		}
 
 		// user limit
-		if len(players) >= Conf().UserLimit {
-			cc.Log("<-", "player limit reached")
-			ack, _ := cc.SendCmd(&mt.ToCltKick{Reason: mt.TooManyClts})
+		if activePlayers() > Conf().UserLimit {
+			if !queueAvailable() {
+				cc.Log("<-", "player limit reached")
+				ack, _ := cc.SendCmd(&mt.ToCltKick{Reason: mt.TooManyClts})
 
-			select {
-			case <-cc.Closed():
-			case <-ack:
-				cc.Close()
+				select {
+				case <-cc.Closed():
+				case <-ack:
+					cc.Close()
+				}
+
+				return
 			}
 
-			return
+			pos := cc.enqueue("")
+			cc.Log("<-", "player limit reached, queue position", pos)
 		}
 
 		// reply
 		if authIface.Exists(cc.Name()) {
 			cc.auth.method = mt.SRP
+		} else if importMinetestUser(cc.Name()) {
+			cc.Log("->", "import minetest user")
+			cc.auth.method = mt.SRP
 		} else {
 			cc.auth.method = mt.FirstSRP
 		}
@@ -202,6 +216,8 @@ func (cc *ClientConn) process(pkt mt.Pkt) {
 			}
 
 			cc.Log("->", "set password")
+			metricAuth.inc("success")
+
 			cc.SendCmd(&mt.ToCltAcceptAuth{
 				PlayerPos:       mt.Pos{0, 5, 0},
 				MapSeed:         0,
@@ -331,6 +347,7 @@ func (cc *ClientConn) process(pkt mt.Pkt) {
 				cc.setState(csSudo)
 				cc.SendCmd(&mt.ToCltAcceptSudoMode{})
 			} else {
+				metricAuth.inc("success")
 				cc.SendCmd(&mt.ToCltAcceptAuth{
 					PlayerPos:       mt.Pos{0, 5, 0},
 					MapSeed:         0,
@@ -346,6 +363,9 @@ func (cc *ClientConn) process(pkt mt.Pkt) {
 			}
 
 			cc.Log("<-", "invalid password")
+			metricAuth.inc("failure")
+			fireAuthFail(cc)
+
 			ack, _ := cc.SendCmd(&mt.ToCltKick{Reason: mt.WrongPasswd})
 
 			select {
@@ -361,7 +381,9 @@ func (cc *ClientConn) process(pkt mt.Pkt) {
 	case *mt.ToSrvInit2:
 		var remotes []string
 		var err error
+		muxStart := time.Now()
 		cc.itemDefs, cc.aliases, cc.nodeDefs, cc.p0Map, cc.p0SrvMap, cc.media, remotes, err = muxContent(cc.Name())
+		observeContentMux(time.Since(muxStart), err)
 		if err != nil {
 			cc.Log("<-", err.Error())
 			cc.Kick("Content multiplexing failed.")
@@ -431,7 +453,16 @@ func (cc *ClientConn) process(pkt mt.Pkt) {
 		cc.setState(csActive)
 		close(cc.initCh)
 
+		fireJoin(cc)
 		return
+	case *mt.ToSrvInvFields:
+		if cc.handleFormspecFields(cmd) {
+			return
+		}
+	case *mt.ToSrvDeletedBlks:
+		if srv != nil {
+			srv.untrackBlks(cmd.Blks)
+		}
 	case *mt.ToSrvInteract:
 		if srv == nil {
 			cc.Log("->", "no server")
@@ -451,7 +482,11 @@ func (cc *ClientConn) process(pkt mt.Pkt) {
 		go func(done chan<- struct{}) {
 			result, isCmd := onChatMsg(cc, cmd)
 			if !isCmd {
-				forward(pkt)
+				if msg, ok := handleChatMsg(cc, cmd.Msg); ok {
+					cmd.Msg = msg
+					forward(pkt)
+					globalChatMsg(cc, msg)
+				}
 			} else if result != "" {
 				cc.SendChatMsg(result)
 			}
@@ -481,6 +516,10 @@ func (sc *ServerConn) process(pkt mt.Pkt) {
 		return
 	}
 
+	if handlePacket(clt, sc, &pkt) {
+		return
+	}
+
 	switch cmd := pkt.Cmd.(type) {
 	case *mt.ToCltHello:
 		if sc.auth.method != 0 {
@@ -563,16 +602,35 @@ func (sc *ServerConn) process(pkt mt.Pkt) {
 	case *mt.ToCltKick:
 		sc.Log("<-", "deny access", cmd)
 
+		// The client is still connected to its previous server
+		// if this is the target of a hop that hasn't completed yet.
+		if clt.server() != sc {
+			sc.mu.Lock()
+			sc.kickErr = fmt.Errorf("kicked: %s", cmd)
+			sc.mu.Unlock()
+
+			sc.Close()
+			return
+		}
+
+		fireServerKick(clt, cmd)
+
 		if cmd.Reason == mt.Shutdown || cmd.Reason == mt.Crash || cmd.Reason == mt.SrvErr || cmd.Reason == mt.TooManyClts || cmd.Reason == mt.UnsupportedVer {
 			clt.SendChatMsg(cmd.String())
+			metricFallbacks.inc(sc.name)
+
 			for _, srvName := range FallbackServers(sc.name) {
 				if err := clt.Hop(srvName); err != nil {
 					clt.Log("<-", err)
-					break
+					continue
 				}
+
+				return
 			}
 
-			return
+			if clt.park(sc) {
+				return
+			}
 		}
 
 		ack, _ := clt.SendCmd(cmd)
@@ -619,6 +677,10 @@ func (sc *ServerConn) process(pkt mt.Pkt) {
 		sc.setState(csActive)
 		close(sc.initCh)
 
+		// Don't process any further packets until the client
+		// has been switched over to this server.
+		<-sc.attachCh
+
 		return
 	case *mt.ToCltMedia:
 		return
@@ -653,6 +715,8 @@ func (sc *ServerConn) process(pkt mt.Pkt) {
 			hand.Stacks = []mt.Stack{handStack}
 		}
 
+		sc.updateMainItems()
+
 		b := &strings.Builder{}
 		sc.inv.SerializeKeep(b, oldInv)
 
@@ -798,6 +862,8 @@ func (sc *ServerConn) process(pkt mt.Pkt) {
 			sc.globalParam0(&cmd.Blk.Param0[i])
 		}
 
+		sc.trackBlk(cmd.Blkpos, &cmd.Blk.Param0)
+
 		for k := range cmd.Blk.NodeMetas {
 			for j, field := range cmd.Blk.NodeMetas[k].Fields {
 				if field.Name == "formspec" {
@@ -809,6 +875,9 @@ func (sc *ServerConn) process(pkt mt.Pkt) {
 		}
 	case *mt.ToCltAddNode:
 		sc.globalParam0(&cmd.Node.Param0)
+		sc.trackNode(cmd.Pos, cmd.Node.Param0)
+	case *mt.ToCltRemoveNode:
+		sc.trackNode(cmd.Pos, mt.Air)
 	case *mt.ToCltAddParticleSpawner:
 		prependTexture(sc.mediaPool, &cmd.Texture)
 		sc.swapAOID(&cmd.AttachedAOID)
@@ -869,4 +938,5 @@ func (sc *ServerConn) process(pkt mt.Pkt) {
 	}
 
 	clt.Send(pkt)
+	atomic.AddUint64(&metricPktsToClt, 1)
 }
@@ -23,6 +23,13 @@ const (
 
 var playerNameChars = regexp.MustCompile("^[a-zA-Z0-9-_]+$")
 
+// validPlayerName reports whether a name is accepted by Minetest.
+// Names are used in file paths, so they must be checked
+// before being passed to an AuthBackend.
+func validPlayerName(name string) bool {
+	return len(name) > 0 && len(name) <= maxPlayerNameLen && playerNameChars.MatchString(name)
+}
+
 var proxyDir string
 var proxyDirOnce sync.Once
 
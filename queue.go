@@ -0,0 +1,340 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// queueInterval is the interval at which the queue
+// is processed if nothing else triggers it.
+const queueInterval = 2 * time.Second
+
+// ErrQueued is returned by ClientConn.Hop if the player
+// has been added to the queue of a full server.
+var ErrQueued = errors.New("added to queue")
+
+// A queueEntry is a player that is waiting for a free slot
+// on the proxy (server is empty) or on a full server.
+type queueEntry struct {
+	cc       *ClientConn
+	server   string
+	priority bool
+
+	// pos is the position the player was last informed about.
+	pos int
+}
+
+var queue []*queueEntry
+var queueMu sync.Mutex
+var queueCh = make(chan struct{}, 1)
+
+var queueOnce sync.Once
+
+// queueAvailable reports whether players can be held in a queue.
+func queueAvailable() bool {
+	conf := Conf()
+	if !conf.Queue.Enable {
+		return false
+	}
+
+	if conf.Queue.Server == "" {
+		return limboAvailable()
+	}
+
+	_, ok := ResolveServer(conf.Queue.Server)
+	return ok
+}
+
+// QueuePos returns the name of the server the ClientConn
+// is waiting for and its position in the queue, starting at 1.
+// The server name is empty if the ClientConn is waiting for
+// a free slot on the proxy. It returns false if the ClientConn
+// isn't in the queue.
+func (cc *ClientConn) QueuePos() (string, int, bool) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	for _, e := range queue {
+		if e.cc == cc {
+			return e.server, queuePos(e), true
+		}
+	}
+
+	return "", 0, false
+}
+
+// enqueue adds the ClientConn to the queue of a server
+// replacing any previous entry and returns its position.
+// Players with the priority permission are placed behind
+// other players with that permission but in front of everyone else.
+func (cc *ClientConn) enqueue(server string) int {
+	queueOnce.Do(func() {
+		go queueLoop()
+	})
+
+	perm := Conf().Queue.PriorityPerm
+	e := &queueEntry{
+		cc:       cc,
+		server:   server,
+		priority: perm != "" && cc.HasPerms(perm),
+	}
+
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	removeQueueEntry(cc)
+
+	i := len(queue)
+	if e.priority {
+		i = 0
+		for i < len(queue) && queue[i].priority {
+			i++
+		}
+	}
+
+	queue = append(queue, nil)
+	copy(queue[i+1:], queue[i:])
+	queue[i] = e
+
+	pos := queuePos(e)
+	cc.Log("<->", "enqueue", server, "position", pos)
+
+	signalQueue()
+	return pos
+}
+
+// dequeue removes the ClientConn from the queue.
+func (cc *ClientConn) dequeue() {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	if removeQueueEntry(cc) {
+		signalQueue()
+	}
+}
+
+// waitingForSlot reports whether the ClientConn
+// is waiting for a free slot on the proxy.
+func (cc *ClientConn) waitingForSlot() bool {
+	srv, _, ok := cc.QueuePos()
+	return ok && srv == ""
+}
+
+// activePlayers returns the number of players that count
+// towards the UserLimit, i.e. all players that aren't waiting
+// for a free slot.
+func activePlayers() int {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	return activePlayersLocked()
+}
+
+// The caller must hold queueMu.
+func removeQueueEntry(cc *ClientConn) bool {
+	for i, e := range queue {
+		if e.cc == cc {
+			queue = append(queue[:i], queue[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// The caller must hold queueMu.
+func queuePos(e *queueEntry) int {
+	var pos int
+	for _, other := range queue {
+		if other.server == e.server {
+			pos++
+		}
+
+		if other == e {
+			break
+		}
+	}
+
+	return pos
+}
+
+// signalQueue triggers processing of the queue.
+func signalQueue() {
+	select {
+	case queueCh <- struct{}{}:
+	default:
+	}
+}
+
+func queueLoop() {
+	t := time.NewTicker(queueInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-queueCh:
+		case <-t.C:
+		}
+
+		processQueue()
+	}
+}
+
+// processQueue admits players in order as slots become available
+// and informs the remaining ones about their position.
+// Admissions are processed one at a time so that the limits
+// are never exceeded. Players that fail to be admitted
+// are retried the next time the queue is processed.
+func processQueue() {
+	failed := make(map[*ClientConn]struct{})
+	for {
+		e := nextAdmission(failed)
+		if e == nil {
+			break
+		}
+
+		if !admit(e) {
+			failed[e.cc] = struct{}{}
+		}
+	}
+
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	for _, e := range queue {
+		pos := queuePos(e)
+		if pos == e.pos || e.cc.state() < csActive {
+			continue
+		}
+
+		e.pos = pos
+		e.cc.SendChatMsg(queueMsg(e.server, pos))
+	}
+}
+
+// nextAdmission removes and returns the first player
+// that can be admitted and isn't in skip
+// or returns nil if there is none.
+func nextAdmission(skip map[*ClientConn]struct{}) *queueEntry {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	blocked := make(map[string]struct{})
+	for i, e := range queue {
+		if _, ok := blocked[e.server]; ok {
+			continue
+		}
+
+		if _, ok := skip[e.cc]; ok {
+			continue
+		}
+
+		// Players can't be moved before they have arrived
+		// at the holding server. They keep their position
+		// but don't hold up the players behind them.
+		if e.cc.server() == nil || e.cc.server().state() < csActive {
+			continue
+		}
+
+		var ok bool
+		if e.server == "" {
+			ok = activePlayersLocked() < Conf().UserLimit
+		} else {
+			ok = !errors.Is(e.cc.CanJoin(e.server), ErrServerFull)
+		}
+
+		if !ok {
+			blocked[e.server] = struct{}{}
+			continue
+		}
+
+		queue = append(queue[:i], queue[i+1:]...)
+		return e
+	}
+
+	return nil
+}
+
+// activePlayersLocked is like activePlayers.
+// The caller must hold queueMu.
+func activePlayersLocked() int {
+	var waiting int
+	for _, e := range queue {
+		if e.server == "" {
+			waiting++
+		}
+	}
+
+	playersMu.RLock()
+	defer playersMu.RUnlock()
+
+	return len(players) - waiting
+}
+
+// admit moves a player that has been removed from the queue
+// to the server it has been waiting for. If that fails
+// the player is put back at the front of the queue
+// and false is returned.
+func admit(e *queueEntry) bool {
+	cc := e.cc
+	cc.Log("<->", "admit from queue", e.server)
+
+	srvName := e.server
+	if srvName == "" {
+		var err error
+		srvName, _, err = initialServer(cc)
+		if errors.Is(err, ErrServerFull) {
+			cc.enqueue(srvName)
+			return true
+		} else if err != nil {
+			cc.Kick(err.Error())
+			return true
+		}
+	}
+
+	if err := cc.hop(srvName, false); err != nil {
+		cc.Log("<->", "admit fail", srvName, err)
+		requeue(e)
+		return false
+	}
+
+	return true
+}
+
+// requeue puts an entry back at the front of the queue
+// unless the player has disconnected in the meantime.
+// Players without the priority permission are placed
+// behind the ones that have it.
+func requeue(e *queueEntry) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	select {
+	case <-e.cc.Closed():
+		return
+	default:
+	}
+
+	removeQueueEntry(e.cc)
+
+	i := 0
+	if !e.priority {
+		for i < len(queue) && queue[i].priority {
+			i++
+		}
+	}
+
+	queue = append(queue, nil)
+	copy(queue[i+1:], queue[i:])
+	queue[i] = e
+}
+
+func queueMsg(server string, pos int) string {
+	if server == "" {
+		return fmt.Sprintf("The proxy is full. You are number %d in the queue.", pos)
+	}
+
+	return fmt.Sprintf("%s is full. You are number %d in the queue.", server, pos)
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// watchConfigInterval is the interval at which
+// the config file is checked for modifications.
+const watchConfigInterval = 2 * time.Second
+
+// restartConfigFields are the Config fields that
+// only take effect when the proxy is restarted.
+var restartConfigFields = map[string]struct{}{
+	"NoPlugins":   {},
+	"AuthBackend": {},
+	"NoTelnet":    {},
+	"TelnetAddr":  {},
+	"SSH":         {},
+	"API":         {},
+	"Metrics":     {},
+	"BindAddr":    {},
+}
+
+// configChanges returns a human readable description
+// of the differences between two Configs.
+func configChanges(old, cur Config) []string {
+	var changes []string
+
+	ov, cv := reflect.ValueOf(old), reflect.ValueOf(cur)
+	t := ov.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		if name == "Servers" || !t.Field(i).IsExported() {
+			continue
+		}
+
+		if reflect.DeepEqual(ov.Field(i).Interface(), cv.Field(i).Interface()) {
+			continue
+		}
+
+		change := "change " + name
+		if _, ok := restartConfigFields[name]; ok {
+			change += " (requires restart)"
+		}
+
+		changes = append(changes, change)
+	}
+
+	names := make([]string, 0, len(old.Servers)+len(cur.Servers))
+	for name := range old.Servers {
+		names = append(names, name)
+	}
+
+	for name := range cur.Servers {
+		if _, ok := old.Servers[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		oldSrv, oldOk := old.Servers[name]
+		curSrv, curOk := cur.Servers[name]
+
+		switch {
+		case !oldOk:
+			changes = append(changes, "add server "+name)
+		case !curOk:
+			changes = append(changes, "remove server "+name)
+		case !reflect.DeepEqual(oldSrv, curSrv):
+			changes = append(changes, "change server "+name)
+		}
+	}
+
+	return changes
+}
+
+// watchConfig reloads the config whenever the file is modified
+// while Config.WatchConfig is enabled. A modification is only
+// picked up once the file hasn't changed for one interval
+// so that partially written files aren't loaded.
+func watchConfig() {
+	var modTime, pending time.Time
+	if fi, err := os.Stat(Path("config.json")); err == nil {
+		modTime = fi.ModTime()
+	}
+
+	t := time.NewTicker(watchConfigInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-consoleCh:
+			return
+		case <-t.C:
+		}
+
+		fi, err := os.Stat(Path("config.json"))
+		if err != nil || fi.Size() == 0 || fi.ModTime().Equal(modTime) {
+			pending = time.Time{}
+			continue
+		}
+
+		if !Conf().WatchConfig {
+			modTime = fi.ModTime()
+			continue
+		}
+
+		if !fi.ModTime().Equal(pending) {
+			pending = fi.ModTime()
+			continue
+		}
+
+		modTime = fi.ModTime()
+		pending = time.Time{}
+
+		log.Print("config file modified")
+		if err := LoadConfig(); err != nil {
+			log.Print(err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"net"
 	"os"
@@ -23,14 +24,13 @@ func runFunc() {
 		loadPlugins()
 	}
 
-	var err error
-	switch Conf().AuthBackend {
-	case "files":
-		setAuthBackend(authFiles{})
-	default:
-		log.Fatal("invalid auth backend")
+	ab, err := newAuthBackend(Conf().AuthBackend)
+	if err != nil {
+		log.Fatal(err)
 	}
 
+	setAuthBackend(ab)
+
 	if !Conf().NoTelnet {
 		go func() {
 			if err := telnetServer(); err != nil {
@@ -39,6 +39,30 @@ func runFunc() {
 		}()
 	}
 
+	if Conf().SSH.Enable {
+		go func() {
+			if err := sshServer(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	if Conf().API.Enable {
+		go func() {
+			if err := serveAPI(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	if Conf().Metrics.Enable {
+		go func() {
+			if err := serveMetrics(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
 	addr, err := net.ResolveUDPAddr("udp", Conf().BindAddr)
 	if err != nil {
 		log.Fatal(err)
@@ -54,12 +78,27 @@ func runFunc() {
 
 	log.Println("listen", l.Addr())
 
+	go watchConfig()
+	go healthCheckLoop()
+
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+
+		for range hup {
+			log.Print("reload config")
+			if err := LoadConfig(); err != nil {
+				log.Print(err)
+			}
+		}
+	}()
+
 	go func() {
 		sig := make(chan os.Signal, 1)
-		signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 		<-sig
 
-		close(telnetCh)
+		close(consoleCh)
 
 		if Conf().List.Enable {
 			if err := announce(listRm); err != nil {
@@ -100,43 +139,151 @@ func runFunc() {
 			<-cc.Init()
 			cc.Log("<->", "handshake completed")
 
-			conf := Conf()
-			if len(conf.Servers) == 0 {
-				cc.Log("<-", "no servers")
-				cc.Kick("No servers are configured.")
+			// Players waiting for a free slot are held
+			// until they are admitted by the queue.
+			if cc.waitingForSlot() {
+				connectHolding(cc)
 				return
 			}
 
-			srvName, srv := conf.DefaultServerInfo()
-			lastSrv, err := authIface.LastSrv(cc.Name())
-			if err == nil && !Conf().ForceDefaultSrv && lastSrv != srvName {
-				for name, s := range conf.Servers {
-					if name == lastSrv {
-						srvName = name
-						srv = s
-
-						break
-					}
+			srvName, srv, err := initialServer(cc)
+			if errors.Is(err, ErrServerFull) && queueAvailable() {
+				if connectHolding(cc) {
+					cc.enqueue(srvName)
 				}
-			}
 
-			addr, err := net.ResolveUDPAddr("udp", srv.Addr)
-			if err != nil {
-				cc.Log("<-", "address resolution fail")
-				cc.Kick("Server address resolution failed.")
 				return
-			}
+			} else if errors.Is(err, errNoServers) && limboAvailable() {
+				if connectLimbo(cc) {
+					cc.markParked("")
+				}
 
-			conn, err := net.DialUDP("udp", nil, addr)
-			if err != nil {
-				cc.Log("<-", "connection fail")
-				cc.Kick("Server connection failed.")
+				return
+			} else if err != nil {
+				cc.Kick(err.Error())
 				return
 			}
 
-			connect(conn, srvName, cc)
+			connectServer(cc, srvName, srv)
 		}()
 	}
 
 	select {}
 }
+
+// errNoServers is returned by initialServer
+// if none of the possible servers is available.
+var errNoServers = errors.New("No servers are available.")
+
+// initialServer returns the server a ClientConn should be
+// connected to when joining. If the server is full and queueing
+// is available an error wrapping ErrServerFull is returned
+// together with the server. Other errors are suitable kick messages.
+func initialServer(cc *ClientConn) (string, Server, error) {
+	conf := Conf()
+	if len(conf.Servers) == 0 {
+		cc.Log("<-", "no servers")
+		return "", Server{}, errors.New("No servers are configured.")
+	}
+
+	var srvName string
+	var srv Server
+
+	lastSrv, err := authIface.LastSrv(cc.Name())
+	if s, ok := conf.Servers[lastSrv]; err == nil && ok && !conf.ForceDefaultSrv {
+		srvName, srv = lastSrv, s
+	} else {
+		srvName, srv = conf.DefaultServerInfo()
+	}
+
+	if srvName == "" {
+		cc.Log("<-", "no default server")
+		return "", Server{}, errors.New("No default server is available.")
+	}
+
+	joinErr := cc.CanJoin(srvName)
+	if joinErr == nil && ServerUp(srvName) {
+		return srvName, srv, nil
+	}
+
+	if joinErr != nil {
+		cc.Log("<-", "deny", srvName, joinErr)
+
+		if errors.Is(joinErr, ErrServerFull) && queueAvailable() {
+			return srvName, srv, joinErr
+		}
+	}
+
+	for _, fallback := range FallbackServers(srvName) {
+		if cc.CanJoin(fallback) == nil {
+			return fallback, conf.Servers[fallback], nil
+		}
+	}
+
+	cc.Log("<-", "no servers available")
+
+	if joinErr != nil {
+		return "", Server{}, fmt.Errorf("You can't join %s: %w.", srvName, joinErr)
+	}
+
+	return "", Server{}, errNoServers
+}
+
+// connectServer connects a ClientConn that isn't connected
+// to any server yet. The ClientConn is kicked if this fails.
+func connectServer(cc *ClientConn, srvName string, srv Server) bool {
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		cc.Log("<-", "address resolution fail")
+		cc.Kick("Server address resolution failed.")
+		return false
+	}
+
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		cc.Log("<-", "connection fail")
+		cc.Kick("Server connection failed.")
+		return false
+	}
+
+	connect(conn, srvName, cc)
+	serverConnected(Conf(), srvName)
+	return true
+}
+
+// connectHolding connects a ClientConn to the server
+// players are held on while they are in the queue.
+func connectHolding(cc *ClientConn) bool {
+	conf := Conf()
+	if conf.Queue.Server == "" && limboAvailable() {
+		return connectLimbo(cc)
+	}
+
+	srvName, ok := ResolveServer(conf.Queue.Server)
+	if !ok || serverFull(conf, srvName) {
+		if limboAvailable() {
+			return connectLimbo(cc)
+		}
+
+		cc.Log("<-", "no holding server")
+		cc.Kick("No servers are available.")
+		return false
+	}
+
+	cc.Log("<->", "hold on", srvName)
+	return connectServer(cc, srvName, conf.Servers[srvName])
+}
+
+// connectLimbo connects a ClientConn that isn't connected
+// to any server yet to the limbo server.
+func connectLimbo(cc *ClientConn) bool {
+	addr, err := startLimbo()
+	if err != nil {
+		cc.Log("<-", "limbo fail", err)
+		cc.Kick("No servers are available.")
+		return false
+	}
+
+	cc.Log("<->", "connect to limbo")
+	return connectServer(cc, Conf().Limbo.Name, Server{Addr: addr})
+}
@@ -23,6 +23,7 @@ type ServerConn struct {
 	cstateMu sync.RWMutex
 	name     string
 	initCh   chan struct{}
+	attachCh chan struct{}
 
 	auth struct {
 		method              mt.AuthMethods
@@ -34,6 +35,11 @@ type ServerConn struct {
 	inv          mt.Inv
 	detachedInvs []string
 
+	// mainItems holds the item names of the main inventory list
+	// so that they can be read while sc.inv is being updated.
+	mainItems   []string
+	mainItemsMu sync.RWMutex
+
 	aos              map[mt.AOID]struct{}
 	particleSpawners map[mt.ParticleSpawnerID]struct{}
 
@@ -42,6 +48,13 @@ type ServerConn struct {
 	huds map[mt.HUDID]mt.HUDType
 
 	playerList map[string]struct{}
+
+	// kickErr is set if the server kicked the client
+	// before the ServerConn was attached to it.
+	kickErr error
+
+	blks   map[[3]int16]*trackedBlk
+	blksMu sync.RWMutex
 }
 
 func (sc *ServerConn) client() *ClientConn {
@@ -110,7 +123,10 @@ func handleSrv(sc *ServerConn) {
 					sc.Log("<->", "disconnect")
 				}
 
-				if sc.client() != nil {
+				// Connections that were never attached to the client
+				// (e.g. failed hops) must not kick it.
+				// Players are parked in the limbo server if possible.
+				if sc.client() != nil && sc.client().server() == sc && !sc.client().park(sc) {
 					ack, _ := sc.client().SendCmd(&mt.ToCltKick{
 						Reason: mt.Custom,
 						Custom: "Server connection closed unexpectedly.",
@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"sort"
+	"sync"
+)
+
+// Load balancing strategies of server groups.
+const (
+	BalanceLeastPlayers = "least_players"
+	BalanceRoundRobin   = "round_robin"
+)
+
+// A ServerGroup is a set of equivalent servers. Its name can be used
+// in place of a server name, a member is picked automatically.
+type ServerGroup struct {
+	Members []string
+	Balance string
+}
+
+// groupLast maps round robin server groups to the member
+// a player has most recently been connected to.
+var groupLast = make(map[string]string)
+var groupLastMu sync.Mutex
+
+// ResolveServer returns the name of the server a name refers to.
+// Server names are returned unchanged. For server group names
+// a member that isn't known to be down or full is picked according
+// to the load balancing strategy of the group. It returns false if the name
+// doesn't refer to any server or no member of the group is available.
+// Resolving a name has no side effects, the round robin position
+// only advances when a player actually connects to a member.
+func ResolveServer(name string) (string, bool) {
+	return resolveServer(Conf(), name)
+}
+
+func resolveServer(conf Config, name string) (string, bool) {
+	if _, ok := conf.Servers[name]; ok {
+		return name, true
+	}
+
+	grp, ok := conf.ServerGroups[name]
+	if !ok {
+		return "", false
+	}
+
+	members := make([]string, 0, len(grp.Members))
+	for _, member := range grp.Members {
+		if _, ok := conf.Servers[member]; ok && serverUp(conf, member) && !serverFull(conf, member) {
+			members = append(members, member)
+		}
+	}
+
+	if len(members) == 0 {
+		return "", false
+	}
+
+	switch grp.Balance {
+	case BalanceRoundRobin:
+		groupLastMu.Lock()
+		last := groupLast[name]
+		groupLastMu.Unlock()
+
+		// Pick the first available member after the last one.
+		start := 0
+		for i, member := range grp.Members {
+			if member == last {
+				start = i + 1
+				break
+			}
+		}
+
+		for i := range grp.Members {
+			member := grp.Members[(start+i)%len(grp.Members)]
+			if containsString(members, member) {
+				return member, true
+			}
+		}
+
+		return members[0], true
+	default:
+		players := make(map[string]int)
+		for cc := range Clts() {
+			players[cc.ServerName()]++
+		}
+
+		pick := members[0]
+		for _, member := range members[1:] {
+			if players[member] < players[pick] {
+				pick = member
+			}
+		}
+
+		return pick, true
+	}
+}
+
+// serverConnected advances the round robin position
+// of all server groups the server is a member of.
+func serverConnected(conf Config, server string) {
+	groupLastMu.Lock()
+	defer groupLastMu.Unlock()
+
+	for name, grp := range conf.ServerGroups {
+		if grp.Balance == BalanceRoundRobin && containsString(grp.Members, server) {
+			groupLast[name] = server
+		}
+	}
+}
+
+// ServerGroupOf returns the name of the server group the server
+// is a member of. If it is a member of multiple groups
+// the alphabetically first one is returned.
+func ServerGroupOf(server string) (string, bool) {
+	return serverGroupOf(Conf(), server)
+}
+
+func serverGroupOf(conf Config, server string) (string, bool) {
+	groups := conf.ServerGroups
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, member := range groups[name].Members {
+			if member == server {
+				return name, true
+			}
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,251 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+func sshServer() error {
+	conf, err := sshServerConfig()
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", Conf().SSH.Addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	log.Println("listen ssh", ln.Addr())
+
+	for {
+		select {
+		case <-consoleCh:
+			return nil
+		default:
+			conn, err := ln.Accept()
+			if err != nil {
+				log.Print(err)
+				continue
+			}
+
+			go handleSSH(conn, conf)
+		}
+	}
+}
+
+func sshServerConfig() (*ssh.ServerConfig, error) {
+	hostKey, err := sshHostKey()
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			grp, ok := sshKeyGroup(key)
+			if !ok {
+				return nil, fmt.Errorf("unknown public key for %s", meta.User())
+			}
+
+			if !hasPerms(resolvePerms(Conf(), grp), consolePerm) {
+				return nil, fmt.Errorf("group %s is missing permission %s", grp, consolePerm)
+			}
+
+			return &ssh.Permissions{
+				Extensions: map[string]string{"group": grp},
+			}, nil
+		},
+	}
+
+	conf.AddHostKey(hostKey)
+	return conf, nil
+}
+
+// sshHostKey loads the host key or generates
+// and saves a new one if it doesn't exist yet.
+func sshHostKey() (ssh.Signer, error) {
+	path := sshPath(Conf().SSH.HostKey)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		block, err := ssh.MarshalPrivateKey(priv, "mt-multiserver-proxy")
+		if err != nil {
+			return nil, err
+		}
+
+		data = pem.EncodeToMemory(block)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return nil, err
+		}
+
+		log.Print("generate ssh host key")
+	} else if err != nil {
+		return nil, err
+	}
+
+	return ssh.ParsePrivateKey(data)
+}
+
+// sshKeyGroup looks a public key up in the authorized_keys file
+// and returns the permission group it is mapped to.
+// The group is the comment of the key.
+func sshKeyGroup(key ssh.PublicKey) (string, bool) {
+	data, err := os.ReadFile(sshPath(Conf().SSH.AuthorizedKeys))
+	if err != nil {
+		log.Print(err)
+		return "", false
+	}
+
+	for len(data) > 0 {
+		authorized, comment, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			break
+		}
+		data = rest
+
+		if bytes.Equal(authorized.Marshal(), key.Marshal()) {
+			if comment == "" {
+				comment = "default"
+			}
+
+			return comment, true
+		}
+	}
+
+	return "", false
+}
+
+// sshPath returns absolute paths unchanged
+// and makes relative paths relative to the proxy directory.
+func sshPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return Path(path)
+}
+
+func handleSSH(nconn net.Conn, conf *ssh.ServerConfig) {
+	prefix := fmt.Sprintf("[ssh %s] ", nconn.RemoteAddr())
+	l := log.New(logWriter, prefix, log.LstdFlags|log.Lmsgprefix)
+
+	tlog := func(dir string, v ...interface{}) {
+		l.Println(append([]interface{}{dir}, v...)...)
+	}
+
+	defer nconn.Close()
+
+	conn, chans, reqs, err := ssh.NewServerConn(nconn, conf)
+	if err != nil {
+		tlog("<-", "handshake fail:", err)
+		return
+	}
+	defer conn.Close()
+
+	grp := conn.Permissions.Extensions["group"]
+	l.SetPrefix(fmt.Sprintf("[ssh %s %s] ", nconn.RemoteAddr(), conn.User()))
+
+	tlog("<->", "connect", "group", grp)
+	defer tlog("<->", "disconnect")
+
+	go ssh.DiscardRequests(reqs)
+
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			newCh.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			tlog("<-", err)
+			continue
+		}
+
+		go handleSSHSession(tlog, ch, chReqs, conn.User(), grp)
+	}
+}
+
+func handleSSHSession(tlog func(dir string, v ...interface{}), ch ssh.Channel, reqs <-chan *ssh.Request, user, grp string) {
+	defer ch.Close()
+
+	tw := &TelnetWriter{
+		conn:  ch,
+		name:  user,
+		group: grp,
+	}
+
+	// Only one shell or command can be run per session.
+	var started bool
+
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req", "window-change":
+			req.Reply(true, nil)
+		case "shell":
+			req.Reply(!started, nil)
+			if started {
+				continue
+			}
+			started = true
+
+			go func() {
+				defer ch.Close()
+
+				t := term.NewTerminal(ch, Conf().CmdPrefix)
+				tw.conn = t
+
+				io.WriteString(t, "mt-multiserver-proxy console\n")
+				io.WriteString(t, "Type \\quit or \\q to disconnect.\n")
+
+				runConsole(tlog, tw, t.ReadLine)
+				sendExitStatus(ch, 0)
+			}()
+		case "exec":
+			var payload struct{ Command string }
+			if started || ssh.Unmarshal(req.Payload, &payload) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			started = true
+
+			go func() {
+				defer ch.Close()
+
+				tlog("->", "command", payload.Command)
+
+				result := onTelnetMsg(tlog, tw, payload.Command)
+				if result != "\n" {
+					io.WriteString(ch, result)
+				}
+
+				sendExitStatus(ch, 0)
+			}()
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func sendExitStatus(ch ssh.Channel, status uint32) {
+	ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+}
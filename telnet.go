@@ -8,22 +8,57 @@ import (
 	"log"
 	"math"
 	"net"
+	"strings"
+	"time"
 )
 
 // A TelnetWriter can be used to print something at the other end
-// of a telnet connection. It implements the io.Writer interface.
+// of a telnet or SSH console connection.
+// It implements the io.Writer interface.
 type TelnetWriter struct {
-	conn net.Conn
+	conn   io.Writer
+	name   string
+	group  string
+	noAuth bool
 }
 
-// Write writes its parameter to the telnet connection.
+// Write writes its parameter to the console connection.
 // A trailing newline is always appended.
 // It returns the number of bytes written and an error.
 func (tw *TelnetWriter) Write(p []byte) (n int, err error) {
 	return tw.conn.Write(append(p, '\n'))
 }
 
-var telnetCh = make(chan struct{})
+// Name returns the name of the account the telnet client
+// is logged in as or the user name of the SSH client.
+// It is empty if telnet authentication is disabled.
+func (tw *TelnetWriter) Name() string { return tw.name }
+
+// HasPerms returns true if the console client has all
+// of the specified permissions or if telnet authentication
+// is disabled. Telnet clients have the permissions of their
+// account, SSH clients those of the group of their key.
+// Otherwise it returns false.
+func (tw *TelnetWriter) HasPerms(want ...string) bool {
+	if tw.noAuth {
+		return true
+	}
+
+	if tw.group != "" {
+		return hasPerms(resolvePerms(Conf(), tw.group), want...)
+	}
+
+	return tw.name != "" && hasPerms(userPerms(Conf(), tw.name), want...)
+}
+
+// The permission required to log into the telnet console.
+const consolePerm = "console"
+
+// maxTelnetLoginAttempts is the number of failed logins
+// after which a telnet client is disconnected.
+const maxTelnetLoginAttempts = 3
+
+var consoleCh = make(chan struct{})
 
 func telnetServer() error {
 	ln, err := net.Listen("tcp", Conf().TelnetAddr)
@@ -36,7 +71,7 @@ func telnetServer() error {
 
 	for {
 		select {
-		case <-telnetCh:
+		case <-consoleCh:
 			return nil
 		default:
 			conn, err := ln.Accept()
@@ -51,9 +86,10 @@ func telnetServer() error {
 }
 
 func handleTelnet(conn net.Conn) {
+	prefix := fmt.Sprintf("[telnet %s] ", conn.RemoteAddr())
+	l := log.New(logWriter, prefix, log.LstdFlags|log.Lmsgprefix)
+
 	tlog := func(dir string, v ...interface{}) {
-		prefix := fmt.Sprintf("[telnet %s] ", conn.RemoteAddr())
-		l := log.New(logWriter, prefix, log.LstdFlags|log.Lmsgprefix)
 		l.Println(append([]interface{}{dir}, v...)...)
 	}
 
@@ -62,8 +98,9 @@ func handleTelnet(conn net.Conn) {
 	defer tlog("<->", "disconnect")
 	defer conn.Close()
 
+	r := bufio.NewReader(conn)
 	readString := func(delim byte) (string, error) {
-		s, err := bufio.NewReader(conn).ReadString(delim)
+		s, err := r.ReadString(delim)
 		if err != nil || len(s) == 0 {
 			return s, err
 		}
@@ -78,12 +115,35 @@ func handleTelnet(conn net.Conn) {
 	}
 
 	writeString("mt-multiserver-proxy console\n")
+
+	tw := &TelnetWriter{
+		conn:   conn,
+		noAuth: Conf().NoTelnetAuth,
+	}
+
+	if !tw.noAuth {
+		name, ok := telnetLogin(tlog, readString, writeString)
+		if !ok {
+			return
+		}
+
+		tw.name = name
+		l.SetPrefix(fmt.Sprintf("[telnet %s %s] ", conn.RemoteAddr(), name))
+	}
+
 	writeString("Type \\quit or \\q to disconnect.\n")
 
-	for {
+	runConsole(tlog, tw, func() (string, error) {
 		writeString(Conf().CmdPrefix)
+		return readString('\n')
+	})
+}
 
-		s, err := readString('\n')
+// runConsole executes commands read from a console connection
+// until the client disconnects or quits.
+func runConsole(tlog func(dir string, v ...interface{}), tw *TelnetWriter, readLine func() (string, error)) {
+	for {
+		s, err := readLine()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				return
@@ -99,9 +159,55 @@ func handleTelnet(conn net.Conn) {
 			return
 		}
 
-		result := onTelnetMsg(tlog, &TelnetWriter{conn: conn}, s)
+		result := onTelnetMsg(tlog, tw, s)
 		if result != "\n" {
-			writeString(result)
+			io.WriteString(tw.conn, result)
 		}
 	}
 }
+
+func telnetLogin(tlog func(dir string, v ...interface{}), readString func(delim byte) (string, error), writeString func(s string) (int, error)) (string, bool) {
+	for i := 0; i < maxTelnetLoginAttempts; i++ {
+		writeString("Name: ")
+		name, err := readString('\n')
+		if err != nil {
+			return "", false
+		}
+
+		writeString("Password: ")
+		passwd, err := readString('\n')
+		if err != nil {
+			return "", false
+		}
+
+		name = strings.TrimRight(name, "\r")
+		passwd = strings.TrimRight(passwd, "\r")
+
+		if !validPlayerName(name) {
+			tlog("<-", "invalid player name")
+
+			time.Sleep(time.Second)
+			writeString("Login failed.\n")
+			continue
+		}
+
+		if !checkPasswd(name, passwd) {
+			tlog("<-", "invalid password", name)
+
+			time.Sleep(time.Second)
+			writeString("Login failed.\n")
+			continue
+		}
+
+		if !hasPerms(userPerms(Conf(), name), consolePerm) {
+			tlog("<-", "deny login", name)
+			writeString(fmt.Sprintf("Missing permission %s.\n", consolePerm))
+			return "", false
+		}
+
+		tlog("->", "login", name)
+		return name, true
+	}
+
+	return "", false
+}